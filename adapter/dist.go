@@ -8,6 +8,7 @@
 package adapter
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -20,6 +21,7 @@ import (
 	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/tiger1103/gfast-token/instance"
 	"reflect"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -357,10 +359,24 @@ func (d *Dist) GetExpire(ctx context.Context, key interface{}) (duration time.Du
 }
 
 func (d *Dist) Remove(ctx context.Context, keys ...interface{}) (lastValue *gvar.Var, err error) {
+	for {
+		lastValue, err = d.remove(keys)
+		// 并发写入冲突时重试，重试时已被删除的key不再返回值
+		if err != badger.ErrConflict {
+			return
+		}
+	}
+}
+
+func (d *Dist) remove(keys []interface{}) (lastValue *gvar.Var, err error) {
 	err = d.db.Update(func(txn *badger.Txn) error {
 		for index, key := range keys {
 			if index == len(keys)-1 {
 				item, err := txn.Get(gconv.Bytes(key))
+				if err == badger.ErrKeyNotFound {
+					// 与内存缓存保持一致，删除不存在的key不返回错误
+					continue
+				}
 				if err != nil {
 					return err
 				}
@@ -421,4 +437,91 @@ func (d *Dist) convertOptionToArgs(option interface{}) (result interface{}, err
 		result = option
 	}
 	return
-}
\ No newline at end of file
+}
+
+// Incr 在事务中将key对应的整数值增加delta并返回新值
+// key不存在时以duration作为过期时间创建，已存在时保持原过期时间
+func (d *Dist) Incr(ctx context.Context, key interface{}, delta int64, duration time.Duration) (value int64, err error) {
+	for {
+		err = d.db.Update(func(txn *badger.Txn) error {
+			ttl := d.getInternalExpire(duration)
+			item, e := txn.Get(gconv.Bytes(key))
+			switch e {
+			case nil:
+				if e = item.Value(func(val []byte) error {
+					value = gconv.Int64(string(val))
+					return nil
+				}); e != nil {
+					return e
+				}
+				if item.ExpiresAt() > 0 {
+					if remain := time.Until(time.Unix(gconv.Int64(item.ExpiresAt()), 0)); remain > 0 {
+						ttl = remain
+					} else {
+						value = 0
+					}
+				}
+			case badger.ErrKeyNotFound:
+				value = 0
+			default:
+				return e
+			}
+			value += delta
+			return txn.SetEntry(badger.NewEntry(gconv.Bytes(key), []byte(strconv.FormatInt(value, 10))).WithTTL(ttl))
+		})
+		// 并发写入冲突时重试
+		if err != badger.ErrConflict {
+			return
+		}
+	}
+}
+
+// SetNX 在事务中仅当key不存在或已过期时写入value，返回是否写入成功
+func (d *Dist) SetNX(ctx context.Context, key interface{}, value interface{}, duration time.Duration) (ok bool, err error) {
+	for {
+		err = d.db.Update(func(txn *badger.Txn) error {
+			_, e := txn.Get(gconv.Bytes(key))
+			switch e {
+			case nil:
+				ok = false
+				return nil
+			case badger.ErrKeyNotFound:
+			default:
+				return e
+			}
+			ok = true
+			return txn.SetEntry(badger.NewEntry(gconv.Bytes(key), gconv.Bytes(value)).WithTTL(d.getInternalExpire(duration)))
+		})
+		// 并发写入冲突时重试
+		if err != badger.ErrConflict {
+			return
+		}
+	}
+}
+
+// RemoveIfEqual 在事务中仅当key对应的值等于value时删除，返回是否删除
+func (d *Dist) RemoveIfEqual(ctx context.Context, key interface{}, value interface{}) (ok bool, err error) {
+	for {
+		err = d.db.Update(func(txn *badger.Txn) error {
+			item, e := txn.Get(gconv.Bytes(key))
+			if e == badger.ErrKeyNotFound {
+				ok = false
+				return nil
+			}
+			if e != nil {
+				return e
+			}
+			if e = item.Value(func(val []byte) error {
+				ok = bytes.Equal(val, gconv.Bytes(value))
+				return nil
+			}); e != nil || !ok {
+				return e
+			}
+			return txn.Delete(gconv.Bytes(key))
+		})
+		// 并发写入冲突时重试
+		if err != badger.ErrConflict {
+			return
+		}
+	}
+}
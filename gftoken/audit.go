@@ -0,0 +1,266 @@
+package gftoken
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/os/glog"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEvent 审计日志记录
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Server    string    `json:"server"`
+	Event     EventType `json:"event"`
+	Success   bool      `json:"success"`
+	UserKey   string    `json:"userKey,omitempty"`
+	Account   string    `json:"account,omitempty"`
+	SessionId string    `json:"sessionId,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	ClientIp  string    `json:"clientIp,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Path      string    `json:"path,omitempty"`
+}
+
+// AuditSink 审计日志输出
+type AuditSink interface {
+	Write(ctx context.Context, e *AuditEvent) error
+}
+
+// AuditHook 将生命周期事件转换为审计日志写入各输出
+type AuditHook struct {
+	sinks []AuditSink
+}
+
+// NewAuditHook 创建审计回调，未指定输出时写入默认日志
+func NewAuditHook(sinks ...AuditSink) *AuditHook {
+	if len(sinks) == 0 {
+		sinks = []AuditSink{NewLogAuditSink()}
+	}
+	return &AuditHook{sinks: sinks}
+}
+
+func (h *AuditHook) OnLogin(ctx context.Context, e *Event)      { h.write(ctx, e) }
+func (h *AuditHook) OnRefresh(ctx context.Context, e *Event)    { h.write(ctx, e) }
+func (h *AuditHook) OnLogout(ctx context.Context, e *Event)     { h.write(ctx, e) }
+func (h *AuditHook) OnAuthFailed(ctx context.Context, e *Event) { h.write(ctx, e) }
+
+func (h *AuditHook) write(ctx context.Context, e *Event) {
+	record := newAuditEvent(e)
+	for _, sink := range h.sinks {
+		if err := sink.Write(ctx, record); err != nil {
+			g.Log().Error(ctx, "[GFToken]write audit log:", err)
+		}
+	}
+}
+
+func newAuditEvent(e *Event) *AuditEvent {
+	record := &AuditEvent{
+		Time:      e.Time,
+		Server:    e.Server,
+		Event:     e.Type,
+		Success:   e.Reason == nil,
+		UserKey:   e.UserKey,
+		Account:   e.Account,
+		SessionId: e.SessionId,
+		ClientIp:  e.ClientIp,
+		UserAgent: e.UserAgent,
+		Path:      e.Path,
+	}
+	if e.Reason != nil {
+		record.Reason = e.Reason.Error()
+	}
+	return record
+}
+
+// Close 关闭实现了 io.Closer 的输出，由 GfToken.Close 在异步写入完成后调用
+func (h *AuditHook) Close() error {
+	var err error
+	for _, sink := range h.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if e := closer.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// LogAuditSink 将审计日志写入glog
+type LogAuditSink struct {
+	logger *glog.Logger
+}
+
+// NewLogAuditSink 创建glog审计输出，默认使用 g.Log()
+func NewLogAuditSink(logger ...*glog.Logger) *LogAuditSink {
+	s := &LogAuditSink{}
+	if len(logger) > 0 {
+		s.logger = logger[0]
+	} else {
+		s.logger = g.Log()
+	}
+	return s
+}
+
+func (s *LogAuditSink) Write(ctx context.Context, e *AuditEvent) error {
+	content, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.logger.Info(ctx, "[GFToken]audit", string(content))
+	return nil
+}
+
+// FileAuditSink 以JSON Lines格式追加写入文件，文件超过MaxSize字节时轮转
+type FileAuditSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	mu         sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// NewFileAuditSink 创建文件审计输出，maxSize为0时不轮转，maxBackups为0时保留全部历史文件
+func NewFileAuditSink(path string, maxSize int64, maxBackups int) *FileAuditSink {
+	return &FileAuditSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+}
+
+func (s *FileAuditSink) Write(ctx context.Context, e *AuditEvent) error {
+	content, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil && s.maxSize > 0 && s.size+int64(len(content)) > s.maxSize {
+		if err = s.rotate(); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		if err = s.open(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(content)
+	s.size += int64(n)
+	return err
+}
+
+// Close 关闭文件
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileAuditSink) open() (err error) {
+	if err = gfile.Mkdir(filepath.Dir(s.path)); err != nil {
+		return
+	}
+	s.file, err = os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return
+	}
+	s.size = info.Size()
+	return
+}
+
+// 将当前文件重命名为 文件名.时间 并清理多余的历史文件
+func (s *FileAuditSink) rotate() (err error) {
+	if err = s.file.Close(); err != nil {
+		return
+	}
+	s.file = nil
+	backup := s.path + "." + time.Now().Format("20060102150405.000000")
+	if err = os.Rename(s.path, backup); err != nil {
+		return
+	}
+	if s.maxBackups <= 0 {
+		return
+	}
+	files, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return
+	}
+	sort.Strings(files)
+	for i := 0; i < len(files)-s.maxBackups; i++ {
+		if strings.HasPrefix(files[i], s.path+".") {
+			if err = os.Remove(files[i]); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// DBAuditSink 将审计日志写入数据库表，参考表结构(MySQL)：
+//
+//	CREATE TABLE `auth_audit` (
+//	  `id`         bigint unsigned NOT NULL AUTO_INCREMENT,
+//	  `time`       datetime        NOT NULL,
+//	  `server`     varchar(64)     NOT NULL DEFAULT '',
+//	  `event`      varchar(32)     NOT NULL DEFAULT '',
+//	  `success`    tinyint(1)      NOT NULL DEFAULT 0,
+//	  `user_key`   varchar(128)    NOT NULL DEFAULT '',
+//	  `account`    varchar(128)    NOT NULL DEFAULT '',
+//	  `session_id` varchar(64)     NOT NULL DEFAULT '',
+//	  `reason`     varchar(255)    NOT NULL DEFAULT '',
+//	  `client_ip`  varchar(64)     NOT NULL DEFAULT '',
+//	  `user_agent` varchar(512)    NOT NULL DEFAULT '',
+//	  `path`       varchar(255)    NOT NULL DEFAULT '',
+//	  PRIMARY KEY (`id`)
+//	);
+type DBAuditSink struct {
+	db    gdb.DB
+	table string
+}
+
+// NewDBAuditSink 创建数据库审计输出
+func NewDBAuditSink(db gdb.DB, table string) *DBAuditSink {
+	return &DBAuditSink{
+		db:    db,
+		table: table,
+	}
+}
+
+func (s *DBAuditSink) Write(ctx context.Context, e *AuditEvent) error {
+	_, err := s.db.Model(s.table).Ctx(ctx).Data(g.Map{
+		"time":       e.Time,
+		"server":     e.Server,
+		"event":      string(e.Event),
+		"success":    e.Success,
+		"user_key":   e.UserKey,
+		"account":    e.Account,
+		"session_id": e.SessionId,
+		"reason":     e.Reason,
+		"client_ip":  e.ClientIp,
+		"user_agent": e.UserAgent,
+		"path":       e.Path,
+	}).Insert()
+	return err
+}
@@ -8,15 +8,23 @@ import (
 )
 
 func (m *GfToken) contains(ctx context.Context, key string) bool {
-	ok, _ := m.cache.Contains(ctx, key)
+	var err error
+	defer m.observe(OpCacheContains, time.Now(), &err)
+	ok, err := m.cache.Contains(ctx, key)
 	return ok
 }
 
-func (m *GfToken) setCache(ctx context.Context, key string, value interface{}) error {
-	return m.cache.Set(ctx, key, value, time.Duration(m.Timeout+m.MaxRefresh)*time.Second)
+func (m *GfToken) setCache(ctx context.Context, key string, value interface{}) (err error) {
+	defer m.observe(OpCacheSet, time.Now(), &err)
+	ctx, span := m.startSpan(ctx, spanCacheSet)
+	defer endSpan(span, &err)
+	return m.cache.Set(ctx, key, value, m.Current().ttl())
 }
 
 func (m *GfToken) getCache(ctx context.Context, key string) (tData *TokenData, err error) {
+	defer m.observe(OpCacheGet, time.Now(), &err)
+	ctx, span := m.startSpan(ctx, spanCacheGet)
+	defer endSpan(span, &err)
 	var result *gvar.Var
 	result, err = m.cache.Get(ctx, key)
 	if err != nil {
@@ -28,7 +36,30 @@ func (m *GfToken) getCache(ctx context.Context, key string) (tData *TokenData, e
 	return
 }
 
+// redis读取并删除脚本，兼容不支持GETDEL的redis版本
+const takeScript = `
+local v = redis.call('GET', KEYS[1])
+if v then
+	redis.call('DEL', KEYS[1])
+end
+return v`
+
+// 读取并删除key，并发调用时只有一个调用方获取到值
+// redis使用脚本原子执行，磁盘缓存在事务中删除，内存缓存在锁中删除
+func (m *GfToken) takeCache(ctx context.Context, key string) (result *gvar.Var, err error) {
+	defer m.observe(OpCacheRemove, time.Now(), &err)
+	ctx, span := m.startSpan(ctx, spanCacheRemove)
+	defer endSpan(span, &err)
+	if m.redis != nil {
+		return m.redis.Eval(ctx, takeScript, 1, []string{key}, nil)
+	}
+	return m.cache.Remove(ctx, key)
+}
+
 func (m *GfToken) removeCache(ctx context.Context, key string) (err error) {
+	defer m.observe(OpCacheRemove, time.Now(), &err)
+	ctx, span := m.startSpan(ctx, spanCacheRemove)
+	defer endSpan(span, &err)
 	_, err = m.cache.Remove(ctx, key)
 	return
 }
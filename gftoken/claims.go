@@ -1,6 +1,9 @@
 package gftoken
 
-import "github.com/golang-jwt/jwt/v5"
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+)
 
 const (
 	//token部分
@@ -8,6 +11,16 @@ const (
 	ErrorsTokenInvalid      string = "无效的token"
 	ErrorsTokenNotActiveYet string = "Token 尚未激活"
 	ErrorsTokenMalFormed    string = "Token 格式不正确"
+	ErrorsSessionLimit      string = "登录设备数已达上限"
+	ErrorsTokenExpired      string = "token已过期"
+	ErrorsDeviceMismatch    string = "登录设备不一致"
+	ErrorsSessionReplaced   string = "账号已在其他设备登录"
+	ErrorsForbidden         string = "没有访问权限"
+	ErrorsTokenRevoked      string = "token已被撤销"
+	ErrorsAuthFailed        string = "token已失效"
+	ErrorsTenantMismatch    string = "token不属于当前租户"
+	ErrorsRealmMismatch     string = "token不属于当前领域"
+	ErrorsSecretUnavailable string = "密钥获取失败"
 
 	JwtTokenOK            int = 200100  //token有效
 	JwtTokenInvalid       int = -400100 //无效的token
@@ -15,7 +28,60 @@ const (
 	JwtTokenFormatErrCode int = -400102 //提交的 Token 格式错误
 )
 
+var (
+	ErrTokenInvalid         = errors.New(ErrorsTokenInvalid)
+	ErrTokenExpired         = errors.New(ErrorsTokenExpired)
+	ErrDeviceMismatch       = errors.New(ErrorsDeviceMismatch)
+	ErrSessionLimitExceeded = errors.New(ErrorsSessionLimit)      // 会话数量达到上限且策略为拒绝新登录
+	ErrForbidden            = errors.New(ErrorsForbidden)         // 角色或授权范围不满足路由要求
+	ErrTokenRevoked         = revokedError{}                      // 会话已退出、撤销或过期清除，errors.Is(err, ErrTokenInvalid) 同样成立
+	ErrTenantMismatch       = errors.New(ErrorsTenantMismatch)    // token所属租户与请求的租户不一致
+	ErrRealmMismatch        = realmMismatchError{}                // token由其他领域的实例签发，errors.Is(err, ErrTokenInvalid) 同样成立
+	ErrSecretUnavailable    = errors.New(ErrorsSecretUnavailable) // 密钥提供者获取密钥失败，刷新成功前不签发及校验token
+)
+
+type revokedError struct{}
+
+func (revokedError) Error() string { return ErrorsTokenRevoked }
+
+func (revokedError) Unwrap() error { return ErrTokenInvalid }
+
+type realmMismatchError struct{}
+
+func (realmMismatchError) Error() string { return ErrorsRealmMismatch }
+
+func (realmMismatchError) Unwrap() error { return ErrTokenInvalid }
+
 type CustomClaims struct {
 	Data interface{}
+	// 授权范围 多个以空格分隔
+	Scope string `json:"scope,omitempty"`
+	// 角色
+	Roles []string `json:"roles,omitempty"`
+	// 所属租户
+	Tenant string `json:"tid,omitempty"`
+	// 签发实例的领域标识
+	Realm string `json:"realm,omitempty"`
 	jwt.RegisteredClaims
 }
+
+// ScopeData 自定义数据实现该接口时，GenerateToken会将其授权范围写入token
+type ScopeData interface {
+	TokenScope() string
+}
+
+// RoleData 自定义数据实现该接口时，GenerateToken会将其角色写入token
+type RoleData interface {
+	TokenRoles() []string
+}
+
+// 获取自定义数据中的授权范围及角色
+func dataGrant(data interface{}) (scope string, roles []string) {
+	if sd, ok := data.(ScopeData); ok {
+		scope = sd.TokenScope()
+	}
+	if rd, ok := data.(RoleData); ok {
+		roles = rd.TokenRoles()
+	}
+	return
+}
@@ -0,0 +1,215 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/tiger1103/gfast-token/adapter"
+	"github.com/tiger1103/gfast-token/instance"
+	"os"
+)
+
+const (
+	// 实例管理中的名称前缀
+	instanceNamePrefix = "gftoken."
+	// 密钥引用前缀
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+)
+
+// 缓存类型
+const (
+	CacheAdapterMemory = "memory"
+	CacheAdapterRedis  = "redis"
+	CacheAdapterDist   = "dist"
+)
+
+// Config 配置文件中的token配置，数值为0或为空时使用默认值
+//
+//	token:
+//	  default:
+//	    serverName:   "app"
+//	    cacheKey:     "app_token_"
+//	    timeout:      864000
+//	    maxRefresh:   432000
+//	    multiLogin:   true
+//	    excludePaths: ["/login", "/public/*"]
+//	    signingKey:   "env:APP_JWT_KEY"
+//	    encryptKey:   "file:/run/secrets/app_aes_key"
+//	    cache:
+//	      adapter: "redis"
+//	      redisGroup: "default"
+type Config struct {
+	ServerName     string
+	Realm          string
+	CacheKey       string
+	Timeout        int64
+	MaxRefresh     *int64 // 为0时token不自动刷新，未配置时使用默认值
+	RefreshTimeout int64
+	MultiLogin     bool
+	ExcludePaths   []string
+	Issuer         string
+	Audience       []string
+	Leeway         int64
+	// 密钥，支持 env:变量名 及 file:文件路径 引用
+	SigningKey string
+	EncryptKey string
+	// 允许使用默认密钥，仅用于开发环境
+	AllowDefaultSecrets bool
+	Cache               CacheConfig
+}
+
+// CacheConfig 缓存配置
+type CacheConfig struct {
+	Adapter    string                 // memory(默认)、redis、dist
+	RedisGroup string                 // 使用redis配置中的分组，未配置Redis时有效，默认default
+	Redis      map[string]interface{} // redis连接配置，同gredis配置
+	Dist       *adapter.Config        // 磁盘缓存配置
+}
+
+// NewFromConfig 读取配置文件中pattern对应的配置(如 token.default)创建实例
+// 同一配置只创建一个实例，重复调用返回已创建的实例
+func NewFromConfig(ctx context.Context, pattern string) (gft *GfToken, err error) {
+	result := instance.GetOrSetFuncLock(instanceNamePrefix+pattern, func() interface{} {
+		var v *GfToken
+		if v, err = newFromConfig(ctx, pattern); err != nil {
+			return nil
+		}
+		return v
+	})
+	if err != nil {
+		return
+	}
+	return result.(*GfToken), nil
+}
+
+func newFromConfig(ctx context.Context, pattern string) (*GfToken, error) {
+	config, err := loadConfig(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := config.options()
+	if err != nil {
+		return nil, gerror.Wrapf(err, `invalid token configuration "%s"`, pattern)
+	}
+	return NewGfTokenE(opts...)
+}
+
+// 读取配置文件中pattern对应的配置
+func loadConfig(ctx context.Context, pattern string) (config *Config, err error) {
+	v, err := g.Cfg().Get(ctx, pattern)
+	if err != nil {
+		return
+	}
+	if v.IsNil() {
+		return nil, invalidConfig(`missing token configuration "` + pattern + `"`)
+	}
+	if err = v.Scan(&config); err != nil {
+		return nil, gerror.Wrapf(err, `invalid token configuration "%s"`, pattern)
+	}
+	return
+}
+
+// 转换为创建实例的选项
+func (c *Config) options() (opts []OptionFunc, err error) {
+	if c.ServerName != "" {
+		opts = append(opts, WithServerName(c.ServerName))
+	}
+	if c.CacheKey != "" {
+		opts = append(opts, WithCacheKey(c.CacheKey))
+	}
+	if c.Timeout != 0 {
+		opts = append(opts, WithTimeout(c.Timeout))
+	}
+	if c.MaxRefresh != nil {
+		opts = append(opts, WithMaxRefresh(*c.MaxRefresh))
+	}
+	if c.RefreshTimeout != 0 {
+		opts = append(opts, WithRefreshTimeout(c.RefreshTimeout))
+	}
+	if c.Leeway != 0 {
+		opts = append(opts, WithLeeway(c.Leeway))
+	}
+	if c.Realm != "" {
+		opts = append(opts, WithRealm(c.Realm))
+	}
+	if c.Issuer != "" {
+		opts = append(opts, WithIssuer(c.Issuer))
+	}
+	if len(c.Audience) > 0 {
+		opts = append(opts, WithAudience(c.Audience...))
+	}
+	opts = append(opts, WithMultiLogin(c.MultiLogin), WithExcludePaths(c.ExcludePaths))
+	if c.AllowDefaultSecrets {
+		opts = append(opts, WithDefaultSecrets())
+	}
+	if c.SigningKey != "" {
+		var key string
+		if key, err = resolveSecret(c.SigningKey); err != nil {
+			return
+		}
+		opts = append(opts, WithUserJwt(key))
+	}
+	if c.EncryptKey != "" {
+		var key string
+		if key, err = resolveSecret(c.EncryptKey); err != nil {
+			return
+		}
+		opts = append(opts, WithEncryptKey([]byte(key)))
+	}
+	cacheOpt, err := c.Cache.option()
+	if err != nil {
+		return
+	}
+	return append(opts, cacheOpt), nil
+}
+
+// 转换为缓存选项
+func (c *CacheConfig) option() (OptionFunc, error) {
+	switch c.Adapter {
+	case "", CacheAdapterMemory:
+		return WithGCache(), nil
+	case CacheAdapterRedis:
+		if len(c.Redis) > 0 {
+			redisConfig, err := gredis.ConfigFromMap(c.Redis)
+			if err != nil {
+				return nil, err
+			}
+			return WithGRedisConfig(redisConfig), nil
+		}
+		// 缺少redis配置时g.Redis会panic，在选项中调用以便 NewGfTokenE 转换为错误
+		group := c.RedisGroup
+		return func(gf *GfToken) {
+			WithGRedis(g.Redis(group))(gf)
+		}, nil
+	case CacheAdapterDist:
+		if c.Dist != nil {
+			return WithDistConfig(c.Dist), nil
+		}
+		return WithDist(), nil
+	}
+	return nil, invalidConfig(`unsupported cache adapter "` + c.Adapter + `"`)
+}
+
+// 解析密钥引用，env:变量名 读取环境变量，file:文件路径 读取文件内容，否则为密钥本身
+func resolveSecret(value string) (string, error) {
+	switch {
+	case gstr.HasPrefix(value, secretEnvPrefix):
+		name := value[len(secretEnvPrefix):]
+		secret, ok := os.LookupEnv(name)
+		if !ok || secret == "" {
+			return "", invalidConfig(`secret environment variable "` + name + `" is not set`)
+		}
+		return secret, nil
+	case gstr.HasPrefix(value, secretFilePrefix):
+		path := value[len(secretFilePrefix):]
+		if !gfile.IsFile(path) {
+			return "", invalidConfig(`secret file "` + path + `" does not exist`)
+		}
+		return gstr.Trim(gfile.GetContents(path)), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,111 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/tiger1103/gfast-token/adapter"
+	"sync"
+	"time"
+)
+
+// redis计数脚本 首次创建时设置过期时间
+const incrScript = `
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n`
+
+// redis加锁脚本 key不存在时写入持有者标识并设置过期时间
+const lockScript = `
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return 0`
+
+// redis解锁脚本 仅当持有者标识一致时删除
+const unlockScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+// 内存缓存计数锁
+var counterMu sync.Mutex
+
+// 计数器加1并返回新值，key不存在时以duration作为过期时间创建
+// redis使用INCR原子操作，磁盘缓存使用badger事务，内存缓存使用进程内锁
+func (m *GfToken) incr(ctx context.Context, key string, duration time.Duration) (int64, error) {
+	if m.redis != nil {
+		v, err := m.redis.Eval(ctx, incrScript, 1, []string{key}, []interface{}{duration.Milliseconds()})
+		if err != nil {
+			return 0, err
+		}
+		return v.Int64(), nil
+	}
+	if dist, ok := m.cache.GetAdapter().(*adapter.Dist); ok {
+		return dist.Incr(ctx, key, 1, duration)
+	}
+	counterMu.Lock()
+	defer counterMu.Unlock()
+	v, err := m.cache.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	n := gconv.Int64(v.String()) + 1
+	if n == 1 {
+		err = m.cache.Set(ctx, key, gconv.String(n), duration)
+	} else {
+		_, _, err = m.cache.Update(ctx, key, gconv.String(n))
+	}
+	return n, err
+}
+
+// 读取计数器当前值
+func (m *GfToken) counter(ctx context.Context, key string) (int64, error) {
+	v, err := m.cache.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	// 磁盘缓存返回[]byte，需按字符串转换
+	return gconv.Int64(v.String()), nil
+}
+
+// 尝试获取锁，key不存在时写入持有者标识owner并以duration作为过期时间，返回是否获取成功
+func (m *GfToken) tryLock(ctx context.Context, key, owner string, duration time.Duration) (bool, error) {
+	if m.redis != nil {
+		v, err := m.redis.Eval(ctx, lockScript, 1, []string{key}, []interface{}{owner, duration.Milliseconds()})
+		if err != nil {
+			return false, err
+		}
+		return v.Int() == 1, nil
+	}
+	if dist, ok := m.cache.GetAdapter().(*adapter.Dist); ok {
+		return dist.SetNX(ctx, key, owner, duration)
+	}
+	counterMu.Lock()
+	defer counterMu.Unlock()
+	return m.cache.SetIfNotExist(ctx, key, owner, duration)
+}
+
+// 释放锁，仅当锁仍由owner持有时删除，锁已过期并被其他调用方获取时不处理
+func (m *GfToken) unlock(ctx context.Context, key, owner string) (bool, error) {
+	if m.redis != nil {
+		v, err := m.redis.Eval(ctx, unlockScript, 1, []string{key}, []interface{}{owner})
+		if err != nil {
+			return false, err
+		}
+		return v.Int() == 1, nil
+	}
+	if dist, ok := m.cache.GetAdapter().(*adapter.Dist); ok {
+		return dist.RemoveIfEqual(ctx, key, owner)
+	}
+	counterMu.Lock()
+	defer counterMu.Unlock()
+	v, err := m.cache.Get(ctx, key)
+	if err != nil || v.String() != owner {
+		return false, err
+	}
+	_, err = m.cache.Remove(ctx, key)
+	return err == nil, err
+}
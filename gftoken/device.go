@@ -0,0 +1,188 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/crypto/gmd5"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DeviceWeb    = "web"
+	DeviceMobile = "mobile"
+
+	// 客户端可通过以下请求头指定设备信息
+	DeviceTypeHeader = "X-Device-Type"
+	DeviceNameHeader = "X-Device-Name"
+	DeviceIdHeader   = "X-Device-Id"
+)
+
+// DeviceInfo 会话的设备及客户端信息
+type DeviceInfo struct {
+	DeviceType  string `json:"deviceType"`
+	DeviceName  string `json:"deviceName"`
+	UserAgent   string `json:"userAgent"`
+	ClientIp    string `json:"clientIp"`
+	Fingerprint string `json:"fingerprint"` // 设备指纹，由UserAgent及设备ID生成
+	LoginAt     int64  `json:"loginAt"`
+	LastSeenAt  int64  `json:"lastSeenAt"`
+}
+
+// Session 用户会话
+type Session struct {
+	Id     string      `json:"id"` // 会话ID，即jwt的jti
+	Device *DeviceInfo `json:"device"`
+}
+
+type deviceCtxKey struct{}
+
+// ContextWithDevice 在上下文中设置登录设备类型，优先于请求头及UserAgent识别的设备类型
+func ContextWithDevice(ctx context.Context, device string) context.Context {
+	return context.WithValue(ctx, deviceCtxKey{}, device)
+}
+
+// DeviceFromContext 获取登录设备类型，依次从上下文、请求头X-Device-Type、UserAgent中识别
+func DeviceFromContext(ctx context.Context) string {
+	if device, _ := ctx.Value(deviceCtxKey{}).(string); device != "" {
+		return device
+	}
+	if r := requestFromCtx(ctx); r != nil {
+		if device := r.Header.Get(DeviceTypeHeader); device != "" {
+			return device
+		}
+		if ua := r.UserAgent(); ua != "" {
+			deviceType, _ := parseUserAgent(ua)
+			return deviceType
+		}
+	}
+	return ""
+}
+
+// 根据登录请求生成设备信息，上下文中没有请求时只记录登录时间及设备类型
+func (m *GfToken) newDeviceInfo(ctx context.Context) *DeviceInfo {
+	now := time.Now().Unix()
+	info := &DeviceInfo{
+		DeviceType: DeviceFromContext(ctx),
+		LoginAt:    now,
+		LastSeenAt: now,
+	}
+	r := requestFromCtx(ctx)
+	if r == nil {
+		return info
+	}
+	info.UserAgent = r.UserAgent()
+	info.ClientIp = m.clientIp(ctx)
+	info.Fingerprint = fingerprint(r)
+	info.DeviceName = r.Header.Get(DeviceNameHeader)
+	if info.DeviceName == "" {
+		_, info.DeviceName = parseUserAgent(info.UserAgent)
+	}
+	return info
+}
+
+// 设备指纹 不包含IP，避免移动网络切换IP导致校验失败
+func fingerprint(r *http.Request) string {
+	return gmd5.MustEncryptString(r.UserAgent() + "|" + r.Header.Get(DeviceIdHeader))
+}
+
+// 根据UserAgent识别设备类型及系统名称
+func parseUserAgent(ua string) (deviceType, deviceName string) {
+	deviceType = DeviceWeb
+	for _, v := range []struct {
+		keyword string
+		name    string
+		mobile  bool
+	}{
+		{"iPhone", "iPhone", true},
+		{"iPad", "iPad", true},
+		{"Android", "Android", true},
+		{"HarmonyOS", "HarmonyOS", true},
+		{"Windows", "Windows", false},
+		{"Macintosh", "Mac OS", false},
+		{"Linux", "Linux", false},
+	} {
+		if strings.Contains(ua, v.keyword) {
+			if v.mobile {
+				deviceType = DeviceMobile
+			}
+			return deviceType, v.name
+		}
+	}
+	return
+}
+
+// 校验请求的设备指纹与登录时是否一致
+func (m *GfToken) verifyDevice(ctx context.Context, tData *TokenData) bool {
+	if !m.deviceBinding || tData.Device == nil || tData.Device.Fingerprint == "" {
+		return true
+	}
+	r := requestFromCtx(ctx)
+	if r == nil {
+		return true
+	}
+	return fingerprint(r) == tData.Device.Fingerprint
+}
+
+// 刷新token时更新最后活跃时间及IP
+func (m *GfToken) touchDevice(ctx context.Context, tData *TokenData) {
+	if tData.Device == nil {
+		return
+	}
+	tData.Device.LastSeenAt = time.Now().Unix()
+	if ip := m.clientIp(ctx); ip != "" {
+		tData.Device.ClientIp = ip
+	}
+}
+
+// GetDevice 获取token对应会话的设备信息
+func (m *GfToken) GetDevice(ctx context.Context, token string) (*DeviceInfo, error) {
+	tData, _, err := m.GetTokenData(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return tData.Device, nil
+}
+
+// GetSessions 获取用户当前所有有效会话，userKey与 Login 相同不足32位时按md5处理；
+// 多点登录时需通过 WithDeviceTracking 或 WithSessionLimit 记录会话列表
+func (m *GfToken) GetSessions(ctx context.Context, userKey string) (sessions []*Session, err error) {
+	if v, e := m.scoped(ctx, ""); e != nil || v != m {
+		if e != nil {
+			return nil, e
+		}
+		return v.GetSessions(ctx, userKey)
+	}
+	if len(userKey) < 32 {
+		userKey = gmd5.MustEncrypt(userKey)
+	}
+	if m.MultiLogin && !m.indexSessions() {
+		return nil, invalidConfig("GetSessions requires WithDeviceTracking when MultiLogin is enabled")
+	}
+	keys := []string{userKey}
+	if m.MultiLogin {
+		var list []sessionEntry
+		if list, err = m.loadSessions(ctx, m.sessionIndexKey(userKey)); err != nil {
+			return
+		}
+		keys = keys[:0]
+		for _, v := range list {
+			keys = append(keys, v.Key)
+		}
+	}
+	for _, key := range keys {
+		var tData *TokenData
+		if tData, err = m.getCache(ctx, m.CacheKey+key); err != nil {
+			return
+		}
+		if tData == nil {
+			continue
+		}
+		session := &Session{Device: tData.Device}
+		if claims, e := m.jwt().ParseToken(tData.JwtToken); e == nil {
+			session.Id = claims.ID
+		}
+		sessions = append(sessions, session)
+	}
+	return
+}
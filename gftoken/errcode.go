@@ -0,0 +1,40 @@
+package gftoken
+
+import (
+	"context"
+	"errors"
+	"github.com/gogf/gf/v2/errors/gcode"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/i18n/gi18n"
+)
+
+// 认证失败错误码，开启 WithCodeError 时使用；GoFrame保留1000以内的错误码，这里以http状态码加两位序号表示
+var (
+	CodeUnauthenticated = gcode.New(40100, "Unauthenticated", nil) // 未携带token或token无效
+	CodeTokenExpired    = gcode.New(40101, "Token Expired", nil)   // token已过期
+	CodeTokenRevoked    = gcode.New(40102, "Token Revoked", nil)   // 会话已退出、撤销或被其他设备顶替
+	CodeForbidden       = gcode.New(40300, "Forbidden", nil)       // 角色或授权范围不满足
+)
+
+// AuthErrorCode 认证失败原因对应的错误码
+func AuthErrorCode(err error) gcode.Code {
+	var replaced *SessionReplacedError
+	switch {
+	case errors.Is(err, ErrForbidden):
+		return CodeForbidden
+	case errors.Is(err, ErrTokenExpired):
+		return CodeTokenExpired
+	case errors.Is(err, ErrTokenRevoked), errors.As(err, &replaced):
+		return CodeTokenRevoked
+	}
+	return CodeUnauthenticated
+}
+
+// 转换为带错误码的gerror，错误信息以响应内容的message为key通过gi18n翻译，响应内容的data作为错误码详情
+func authError(ctx context.Context, err error, failed *AuthFailed) error {
+	code := AuthErrorCode(err)
+	if failed.Data != nil {
+		code = gcode.WithCode(code, failed.Data)
+	}
+	return gerror.NewCode(code, gi18n.T(ctx, failed.Message))
+}
@@ -5,14 +5,18 @@ import (
 	"errors"
 	"github.com/gogf/gf/v2/crypto/gaes"
 	"github.com/gogf/gf/v2/crypto/gmd5"
+	"github.com/gogf/gf/v2/database/gredis"
 	"github.com/gogf/gf/v2/encoding/gbase64"
 	"github.com/gogf/gf/v2/errors/gerror"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
 	"github.com/gogf/gf/v2/os/gcache"
+	"github.com/gogf/gf/v2/os/gtimer"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/gogf/gf/v2/util/grand"
+	"github.com/gogf/gf/v2/util/guid"
 	"github.com/golang-jwt/jwt/v5"
+	"net"
 	"time"
 )
 
@@ -21,80 +25,251 @@ type GfToken struct {
 	ServerName string
 	// 缓存key (每创建一个实例CacheKey必须不相同)
 	CacheKey string
+	// Timeout、MaxRefresh、ExcludePaths、EncryptKey为创建时的配置，创建后修改不生效，运行中请通过 Reload 或 ReloadFunc 更新
 	// 超时时间 默认10天（秒）
 	Timeout int64
 	// 缓存刷新时间 默认5天（秒）
 	// 处理携带token的请求时当前时间大于超时时间并小于缓存刷新时间时token将自动刷新即重置token存活时间
 	// MaxRefresh值为0时,token将不会自动刷新
 	MaxRefresh int64
+	// 刷新令牌有效期 默认30天（秒）
+	RefreshTimeout int64
 	// 是否允许多点登录
 	MultiLogin bool
 	// Token加密key 32位
 	EncryptKey []byte
 	// 缓存 (缓存模式:gcache 或 gredis)
 	cache *gcache.Cache
+	// 使用gredis缓存时的redis客户端，用于原子计数
+	redis *gredis.Redis
 	// 拦截排除地址
 	ExcludePaths g.SliceStr
 	// jwt
 	userJwt *JwtSign
+	// jwt签发者 为空时使用ServerName，设置后解析token时将校验iss
+	Issuer string
+	// jwt受众 设置后解析token时将校验aud
+	Audience g.SliceStr
+	// 校验jwt时间时允许的时钟偏差 默认10秒
+	Leeway int64
+	// 调用token内省等接口的客户端凭证 clientId => clientSecret
+	clients map[string]string
+	// 退出接口响应内容
+	logoutResponse interface{}
+	// 撤销接口响应内容 默认为空
+	revokeResponse interface{}
+	// 登录接口是否写入cookie
+	loginCookie bool
+	// 登录cookie是否始终设置Secure，为false时仅HTTPS请求设置
+	loginCookieSecure bool
+	// 登录失败限制 为nil时不限制
+	throttle *ThrottleConfig
+	// 可信代理，只有直连地址属于可信代理时才读取X-Forwarded-For、X-Real-IP
+	trustedProxies []*net.IPNet
+	// 会话数量限制 为nil时不限制
+	sessionLimit *SessionLimit
+	// 是否校验请求的设备指纹与登录时一致
+	deviceBinding bool
+	// 多点登录时是否记录用户的会话列表
+	deviceTracking bool
+	// 会话被顶替的标记保留时间 默认1天（秒），为0时不记录
+	ReplacedTimeout int64
+	// 会话被新登录顶替时的回调
+	onSessionReplaced func(ctx context.Context, replaced *SessionReplaced)
+	// 生命周期回调
+	hooks []hookEntry
+	// 指标收集 为nil时不收集
+	metrics Metrics
+	// 是否开启链路追踪
+	tracing bool
+	// 是否允许使用默认密钥
+	defaultSecrets bool
+	// 可热更新的配置快照
+	snapshot *snapshotState
+	// 认证失败时是否设置带错误码的gerror代替直接输出响应
+	codeError bool
+	// 密钥提供者及刷新间隔
+	secretProvider SecretProvider
+	secretRefresh  time.Duration
+	// 创建时获取密钥失败的错误
+	secretErr error
+	// 定时刷新密钥的任务
+	secretTimer *gtimer.Entry
+	// 签发的token携带的领域标识
+	realm string
+	// 多租户配置 为nil时不区分租户
+	tenants *tenantState
+	// 租户实例所属租户
+	tenant string
 }
 
 // TokenData Token 数据
 type TokenData struct {
-	JwtToken string `json:"jwtToken"`
-	UuId     string `json:"uuId"`
+	JwtToken     string      `json:"jwtToken"`
+	UuId         string      `json:"uuId"`
+	RefreshToken string      `json:"refreshToken,omitempty"` // 关联的刷新令牌缓存key
+	Device       *DeviceInfo `json:"device,omitempty"`       // 登录设备信息
 }
 
 // 存活时间 (存活时间 = 超时时间 + 缓存刷新时间)
 func (m *GfToken) diedLine() time.Time {
-	return time.Now().Add(time.Second * time.Duration(m.Timeout+m.MaxRefresh))
+	return m.Current().diedLine()
+}
+
+// jwt签发者
+func (m *GfToken) issuer() string {
+	if m.Issuer != "" {
+		return m.Issuer
+	}
+	return m.ServerName
 }
 
 // 生成token
 func (m *GfToken) GenerateToken(ctx context.Context, key string, data interface{}) (keys string, err error) {
+	scope, roles := dataGrant(data)
+	keys, _, err = m.generateToken(ctx, key, data, scope, roles, "")
+	return
+}
+
+// 生成token scope为授权范围，roles为角色，refresh为关联的刷新令牌缓存key，返回token及会话缓存key
+func (m *GfToken) generateToken(ctx context.Context, key string, data interface{}, scope string, roles []string, refresh string) (
+	keys, session string, err error) {
+	if v, e := m.scoped(ctx, ""); e != nil || v != m {
+		if e != nil {
+			return "", "", e
+		}
+		return v.generateToken(ctx, key, data, scope, roles, refresh)
+	}
+	defer m.observe(OpGenerate, time.Now(), &err)
+	var (
+		claims   *CustomClaims
+		replaced []*SessionReplaced
+	)
+	keys, session, claims, replaced, err = m.createToken(ctx, key, data, scope, roles, refresh)
+	if err != nil {
+		return
+	}
+	// 回调在释放会话索引锁之后执行，回调中可再次操作token
+	for _, v := range replaced {
+		m.onReplaced(ctx, v)
+	}
+	m.emit(ctx, newEvent(EventLogin, claims, nil))
+	return
+}
+
+// 创建token并写入缓存，返回会话缓存key及被顶替的会话
+func (m *GfToken) createToken(ctx context.Context, key string, data interface{}, scope string, roles []string, refresh string) (
+	keys, session string, claims *CustomClaims, replaced []*SessionReplaced, err error) {
 	if len(key) < 32 {
 		err = gerror.New("key length must more than 32")
 		return
 	}
 	var (
-		uuid   string
-		tokens string
+		uuid    string
+		tokens  string
+		now     = time.Now()
+		userKey = key
+		conf    = m.Current()
 	)
+	if conf.secretErr != nil {
+		err = ErrSecretUnavailable
+		return
+	}
 	// 支持多端重复登录，返回新token
 	if m.MultiLogin {
 		key = gstr.SubStr(key, 0, len(key)-16) + grand.Letters(16)
 	}
-	tokens, err = m.userJwt.CreateToken(CustomClaims{
-		data,
-		jwt.RegisteredClaims{
-			NotBefore: jwt.NewNumericDate(time.Unix(time.Now().Unix()-10, 0)), // 生效开始时间
-			ExpiresAt: jwt.NewNumericDate(m.diedLine()),                       // 失效截止时间
+	// 限制会话数量或记录会话列表时维护用户会话索引
+	var (
+		device   = m.newDeviceInfo(ctx)
+		indexKey string
+		sessions []sessionEntry
+	)
+	if m.indexSessions() {
+		indexKey = m.sessionIndexKey(key)
+		var unlock func()
+		if unlock, err = m.lockSessions(ctx, indexKey); err != nil {
+			return
+		}
+		defer unlock()
+		if m.sessionLimit != nil {
+			sessions, replaced, err = m.checkSessionLimit(ctx, indexKey, device)
+		} else {
+			sessions, err = m.loadSessions(ctx, indexKey)
+		}
+		if err != nil {
+			return
+		}
+	}
+	// 不允许多点登录时新登录将顶替旧会话
+	var old *TokenData
+	if !m.MultiLogin {
+		if old, err = m.getCache(ctx, m.CacheKey+key); err != nil {
+			return
+		}
+	}
+	claims = &CustomClaims{
+		Data:   data,
+		Scope:  scope,
+		Roles:  roles,
+		Tenant: m.tenant,
+		Realm:  m.realm,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer(),                          // 签发者
+			Subject:   userKey,                             // 用户标识
+			Audience:  jwt.ClaimStrings(m.Audience),        // 受众
+			ID:        guid.S(),                            // token唯一标识
+			IssuedAt:  jwt.NewNumericDate(now),             // 签发时间
+			NotBefore: jwt.NewNumericDate(now),             // 生效开始时间
+			ExpiresAt: jwt.NewNumericDate(conf.diedLine()), // 失效截止时间
 		},
-	})
+	}
+	_, span := m.startSpan(ctx, spanJwtSign)
+	tokens, err = conf.jwt.CreateToken(*claims)
+	endSpan(span, &err)
 	if err != nil {
 		return
 	}
-	keys, uuid, err = m.EncryptToken(ctx, key)
+	keys, uuid, err = m.encryptToken(ctx, conf, key)
 	if err != nil {
 		return
 	}
 	err = m.setCache(ctx, m.CacheKey+key, TokenData{
-		JwtToken: tokens,
-		UuId:     uuid,
+		JwtToken:     tokens,
+		UuId:         uuid,
+		RefreshToken: refresh,
+		Device:       device,
 	})
 	if err != nil {
 		return
 	}
+	if m.indexSessions() {
+		err = m.saveSessions(ctx, indexKey, append(sessions, newSessionEntry(key, device.DeviceType)))
+	}
+	if old != nil {
+		replaced = append(replaced, m.replaceSession(ctx, old, device))
+	}
+	session = key
 	return
 }
 
 // 解析token (只验证格式并不验证过期)
 func (m *GfToken) ParseToken(r *ghttp.Request) (*CustomClaims, error) {
-	token, err := m.GetToken(r)
+	return m.parseToken(r.GetCtx(), m.GetRequestToken(r))
+}
+
+func (m *GfToken) parseToken(ctx context.Context, token string) (*CustomClaims, error) {
+	if v, err := m.scoped(ctx, token); err != nil || v != m {
+		if err != nil {
+			return nil, err
+		}
+		return v.parseToken(ctx, token)
+	}
+	tData, _, err := m.GetTokenData(ctx, token)
 	if err != nil {
 		return nil, err
 	}
-	if customClaims, err := m.userJwt.ParseToken(token.JwtToken); err == nil {
+	if customClaims, err := m.jwt().ParseToken(tData.JwtToken); err == nil {
 		return customClaims, nil
 	} else {
 		return &CustomClaims{}, errors.New(ErrorsParseTokenFail)
@@ -103,35 +278,118 @@ func (m *GfToken) ParseToken(r *ghttp.Request) (*CustomClaims, error) {
 
 // 检查缓存的token是否有效且自动刷新缓存token
 func (m *GfToken) IsEffective(ctx context.Context, token string) bool {
-	cacheToken, key, err := m.GetTokenData(ctx, token)
+	err := m.CheckToken(ctx, token)
 	if err != nil {
 		g.Log().Info(ctx, err)
 		return false
 	}
-	_, code := m.IsNotExpired(cacheToken.JwtToken)
-	if JwtTokenOK == code {
-		// 刷新缓存
-		if m.IsRefresh(cacheToken.JwtToken) {
-			return m.doRefresh(ctx, key, cacheToken)
+	return true
+}
+
+// CheckToken 检查缓存的token是否有效且自动刷新缓存token，无效时返回原因
+// 会话被新登录顶替时返回 *SessionReplacedError
+func (m *GfToken) CheckToken(ctx context.Context, token string) error {
+	_, err := m.checkToken(ctx, token)
+	return err
+}
+
+// 检查token并返回解析后的claims
+func (m *GfToken) checkToken(ctx context.Context, token string) (claims *CustomClaims, err error) {
+	return m.verifyToken(ctx, token, true)
+}
+
+// 检查token，refresh为false时只校验会话是否有效，不刷新token及会话有效期
+func (m *GfToken) verifyToken(ctx context.Context, token string, refresh bool) (claims *CustomClaims, err error) {
+	if v, e := m.scoped(ctx, token); e != nil || v != m {
+		if e != nil {
+			return nil, e
 		}
-		return true
+		return v.verifyToken(ctx, token, refresh)
 	}
-	return false
+	defer m.observe(OpValidate, time.Now(), &err)
+	// 整个校验过程使用同一个配置快照
+	conf := m.Current()
+	key, uuid, err := m.decryptToken(ctx, conf, token)
+	if err != nil {
+		if errors.Is(err, ErrRealmMismatch) {
+			return nil, err
+		}
+		return nil, ErrTokenInvalid
+	}
+	cacheToken, err := m.getCache(ctx, m.CacheKey+key)
+	if err != nil {
+		return
+	}
+	if cacheToken == nil || cacheToken.UuId != uuid {
+		if replaced := m.getReplaced(ctx, uuid); replaced != nil {
+			return nil, &SessionReplacedError{Replaced: replaced}
+		}
+		return nil, ErrTokenRevoked
+	}
+	if !m.verifyDevice(ctx, cacheToken) {
+		return nil, ErrDeviceMismatch
+	}
+	_, span := m.startSpan(ctx, spanJwtParse)
+	claims, code := conf.isNotExpired(cacheToken.JwtToken)
+	switch code {
+	case JwtTokenOK:
+	case JwtTokenExpired:
+		err = ErrTokenExpired
+	default:
+		err = ErrTokenInvalid
+	}
+	if err == nil && claims.Tenant != m.tenant {
+		err = ErrTenantMismatch
+	}
+	// 未携带领域的token兼容启用领域前签发的token
+	if err == nil && claims.Realm != "" && claims.Realm != m.realm {
+		err = ErrRealmMismatch
+	}
+	endSpan(span, &err)
+	if err != nil {
+		return nil, err
+	}
+	// 刷新缓存
+	if refresh && conf.isRefresh(cacheToken.JwtToken) {
+		if !m.doRefresh(ctx, conf, key, cacheToken) {
+			return nil, gerror.New("refresh token failed")
+		}
+		m.traceRefreshed(ctx)
+	}
+	return
 }
 
-func (m *GfToken) doRefresh(ctx context.Context, key string, cacheToken *TokenData) bool {
-	if newToken, err := m.RefreshToken(cacheToken.JwtToken); err == nil {
+func (m *GfToken) doRefresh(ctx context.Context, conf *Snapshot, key string, cacheToken *TokenData) bool {
+	var (
+		newToken string
+		err      error
+	)
+	defer m.observe(OpRefresh, time.Now(), &err)
+	_, span := m.startSpan(ctx, spanJwtSign)
+	newToken, err = conf.refreshToken(cacheToken.JwtToken)
+	endSpan(span, &err)
+	if err == nil {
 		cacheToken.JwtToken = newToken
+		m.touchDevice(ctx, cacheToken)
 		err = m.setCache(ctx, m.CacheKey+key, cacheToken)
 		if err != nil {
 			g.Log().Error(ctx, err)
 			return false
 		}
+		m.touchSessions(ctx, conf, key)
+		claims, _ := conf.jwt.ParseToken(newToken)
+		m.emit(ctx, newEvent(EventRefresh, claims, nil))
 	}
 	return true
 }
 
 func (m *GfToken) GetTokenData(ctx context.Context, token string) (tData *TokenData, key string, err error) {
+	if v, e := m.scoped(ctx, token); e != nil || v != m {
+		if e != nil {
+			return nil, "", e
+		}
+		return v.GetTokenData(ctx, token)
+	}
 	var uuid string
 	key, uuid, err = m.DecryptToken(ctx, token)
 	if err != nil {
@@ -146,7 +404,11 @@ func (m *GfToken) GetTokenData(ctx context.Context, token string) (tData *TokenD
 
 // 检查token是否过期 (过期时间 = 超时时间 + 缓存刷新时间)
 func (m *GfToken) IsNotExpired(token string) (*CustomClaims, int) {
-	if customClaims, err := m.userJwt.ParseToken(token); err == nil {
+	return m.Current().isNotExpired(token)
+}
+
+func (s *Snapshot) isNotExpired(token string) (*CustomClaims, int) {
+	if customClaims, err := s.jwt.ParseToken(token); err == nil {
 		if time.Now().Unix()-customClaims.ExpiresAt.Unix() < 0 {
 			// token有效
 			return customClaims, JwtTokenOK
@@ -162,7 +424,11 @@ func (m *GfToken) IsNotExpired(token string) (*CustomClaims, int) {
 
 // 刷新token的缓存有效期
 func (m *GfToken) RefreshToken(oldToken string) (newToken string, err error) {
-	if newToken, err = m.userJwt.RefreshToken(oldToken, m.diedLine().Unix()); err != nil {
+	return m.Current().refreshToken(oldToken)
+}
+
+func (s *Snapshot) refreshToken(oldToken string) (newToken string, err error) {
+	if newToken, err = s.jwt.RefreshToken(oldToken, s.diedLine().Unix()); err != nil {
 		return
 	}
 	return
@@ -170,12 +436,16 @@ func (m *GfToken) RefreshToken(oldToken string) (newToken string, err error) {
 
 // token是否处于刷新期
 func (m *GfToken) IsRefresh(token string) bool {
-	if m.MaxRefresh == 0 {
+	return m.Current().isRefresh(token)
+}
+
+func (s *Snapshot) isRefresh(token string) bool {
+	if s.MaxRefresh == 0 {
 		return false
 	}
-	if customClaims, err := m.userJwt.ParseToken(token); err == nil {
+	if customClaims, err := s.jwt.ParseToken(token); err == nil {
 		now := time.Now().Unix()
-		if now < customClaims.ExpiresAt.Unix() && now > (customClaims.ExpiresAt.Unix()-m.MaxRefresh) {
+		if now < customClaims.ExpiresAt.Unix() && now > (customClaims.ExpiresAt.Unix()-s.MaxRefresh) {
 			return true
 		}
 	}
@@ -184,39 +454,78 @@ func (m *GfToken) IsRefresh(token string) bool {
 
 // EncryptToken token加密方法
 func (m *GfToken) EncryptToken(ctx context.Context, key string, randStr ...string) (encryptStr, uuid string, err error) {
+	return m.encryptToken(ctx, m.Current(), key, randStr...)
+}
+
+func (m *GfToken) encryptToken(ctx context.Context, conf *Snapshot, key string, randStr ...string) (encryptStr, uuid string, err error) {
 	if key == "" {
 		err = gerror.New("encrypt key empty")
 		return
 	}
+	if conf.secretErr != nil {
+		err = ErrSecretUnavailable
+		return
+	}
 	// 生成随机串
 	if len(randStr) > 0 {
 		uuid = randStr[0]
 	} else {
 		uuid = gmd5.MustEncrypt(grand.Letters(10))
 	}
-	token, err := gaes.Encrypt([]byte(key+uuid), m.EncryptKey)
+	token, err := gaes.Encrypt([]byte(key+uuid), conf.EncryptKey)
 	if err != nil {
 		g.Log().Error(ctx, "[GFToken]encrypt error Token:", key, err)
 		err = gerror.New("encrypt error")
 		return
 	}
 	encryptStr = gbase64.EncodeToString(token)
+	encryptStr = m.tenantPrefix() + encryptStr
+	if m.realm != "" {
+		encryptStr = m.realm + RealmSeparator + encryptStr
+	}
 	return
 }
 
 // DecryptToken token解密方法
 func (m *GfToken) DecryptToken(ctx context.Context, token string) (DecryptStr, uuid string, err error) {
+	if v, e := m.scoped(ctx, token); e != nil || v != m {
+		if e != nil {
+			return "", "", e
+		}
+		return v.DecryptToken(ctx, token)
+	}
+	return m.decryptToken(ctx, m.Current(), token)
+}
+
+func (m *GfToken) decryptToken(ctx context.Context, conf *Snapshot, token string) (DecryptStr, uuid string, err error) {
+	ctx, span := m.startSpan(ctx, spanDecrypt)
+	defer endSpan(span, &err)
 	if token == "" {
 		err = gerror.New("decrypt Token empty")
 		return
 	}
+	if conf.secretErr != nil {
+		err = ErrSecretUnavailable
+		return
+	}
+	// 其他领域的token不尝试解密，未携带领域标识的token兼容启用领域前签发的token
+	realm, _ := splitRealm(token)
+	if realm != "" && realm != m.realm {
+		err = ErrRealmMismatch
+		return
+	}
+	tenant, token := splitTenant(token)
+	if tenant != m.tenant {
+		err = ErrTenantMismatch
+		return
+	}
 	token64, err := gbase64.Decode([]byte(token))
 	if err != nil {
 		g.Log().Info(ctx, "[GFToken]decode error Token:", token, err)
 		err = gerror.New("decode error")
 		return
 	}
-	decryptToken, err := gaes.Decrypt(token64, m.EncryptKey)
+	decryptToken, err := conf.decrypt(token64)
 	if err != nil {
 		g.Log().Info(ctx, "[GFToken]decrypt error Token:", token, err)
 		err = gerror.New("decrypt error")
@@ -230,11 +539,43 @@ func (m *GfToken) DecryptToken(ctx context.Context, token string) (DecryptStr, u
 
 // RemoveToken 删除token
 func (m *GfToken) RemoveToken(ctx context.Context, token string) (err error) {
-	var key string
-	_, key, err = m.GetTokenData(ctx, token)
+	return m.removeToken(ctx, token, EventLogout)
+}
+
+// 删除token并触发对应的生命周期事件
+func (m *GfToken) removeToken(ctx context.Context, token string, eventType EventType) (err error) {
+	if v, e := m.scoped(ctx, token); e != nil || v != m {
+		if e != nil {
+			return e
+		}
+		return v.removeToken(ctx, token, eventType)
+	}
+	defer m.observe(OpRemove, time.Now(), &err)
+	var (
+		key   string
+		tData *TokenData
+	)
+	tData, key, err = m.GetTokenData(ctx, token)
 	if err != nil {
 		return
 	}
-	err = m.removeCache(ctx, m.CacheKey+key)
+	if err = m.removeSession(ctx, key); err != nil {
+		return
+	}
+	if m.indexSessions() {
+		if err = m.dropSession(ctx, key); err != nil {
+			return
+		}
+	}
+	claims, _ := m.jwt().ParseToken(tData.JwtToken)
+	m.emit(ctx, newEvent(eventType, claims, nil))
 	return
 }
+
+// Close 停止定时刷新密钥，等待异步回调(如审计日志)执行完成并关闭其输出，实例不再使用时调用
+func (m *GfToken) Close() {
+	if m.secretTimer != nil {
+		m.secretTimer.Close()
+	}
+	m.closeHooks()
+}
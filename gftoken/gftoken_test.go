@@ -0,0 +1,2200 @@
+package gftoken_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/container/garray"
+	"github.com/gogf/gf/v2/container/gtype"
+	"github.com/gogf/gf/v2/crypto/gmd5"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/encoding/gurl"
+	"github.com/gogf/gf/v2/errors/gcode"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/guid"
+	"github.com/gorilla/websocket"
+	"github.com/tiger1103/gfast-token/adapter"
+	"github.com/tiger1103/gfast-token/gftoken"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var ctx = context.Background()
+
+func Test_RegisteredClaims(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithServerName("serviceA"),
+			gftoken.WithCacheKey("test_claims_"),
+			gftoken.WithGCache(),
+		)
+		key := gmd5.MustEncrypt("claims")
+		token, err := gft.GenerateToken(ctx, key, "data")
+		t.AssertNil(err)
+		tData, _, err := gft.GetTokenData(ctx, token)
+		t.AssertNil(err)
+		claims, code := gft.IsNotExpired(tData.JwtToken)
+		t.Assert(code, gftoken.JwtTokenOK)
+		t.Assert(claims.Issuer, "serviceA")
+		t.Assert(claims.Subject, key)
+		t.AssertNE(claims.ID, "")
+		t.AssertNE(claims.IssuedAt, nil)
+		t.Assert(claims.Data, "data")
+	})
+}
+
+func Test_IssuerAudience(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			a = gftoken.NewGfToken(
+				gftoken.WithCacheKey("test_aud_"),
+				gftoken.WithIssuer("serviceA"),
+				gftoken.WithAudience("serviceA"),
+				gftoken.WithGCache(),
+			)
+			b = gftoken.NewGfToken(
+				gftoken.WithCacheKey("test_aud_"),
+				gftoken.WithIssuer("serviceB"),
+				gftoken.WithAudience("serviceB"),
+			)
+			c = gftoken.NewGfToken(
+				gftoken.WithCacheKey("test_aud_"),
+				gftoken.WithAudience("serviceB", "serviceA"),
+			)
+		)
+		token, err := a.GenerateToken(ctx, gmd5.MustEncrypt("audience"), nil)
+		t.AssertNil(err)
+		tData, _, err := a.GetTokenData(ctx, token)
+		t.AssertNil(err)
+		_, code := a.IsNotExpired(tData.JwtToken)
+		t.Assert(code, gftoken.JwtTokenOK)
+		_, code = b.IsNotExpired(tData.JwtToken)
+		t.Assert(code, gftoken.JwtTokenInvalid)
+		_, code = c.IsNotExpired(tData.JwtToken)
+		t.Assert(code, gftoken.JwtTokenOK)
+	})
+}
+
+type scopedUser struct {
+	Name string
+}
+
+func (u scopedUser) TokenScope() string {
+	return "read:user write:user"
+}
+
+func Test_Introspect(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_introspect_"),
+			gftoken.WithGCache(),
+		)
+		key := gmd5.MustEncrypt("introspect")
+		token, err := gft.GenerateToken(ctx, key, scopedUser{Name: "john"})
+		t.AssertNil(err)
+		res := gft.Introspect(ctx, token)
+		t.Assert(res.Active, true)
+		t.Assert(res.Sub, key)
+		t.Assert(res.Scope, "read:user write:user")
+		t.Assert(res.Data, map[string]interface{}{"Name": "john"})
+		t.AssertGT(res.Exp, res.Iat)
+
+		t.AssertNil(gft.RemoveToken(ctx, token))
+		t.Assert(gft.Introspect(ctx, token).Active, false)
+		t.Assert(gft.Introspect(ctx, "invalid").Active, false)
+	})
+}
+
+func Test_IntrospectHandler(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_introspect_handler_"),
+		gftoken.WithClientCredentials("resource", "secret"),
+		gftoken.WithGCache(),
+	)
+	s := g.Server(guid.S())
+	s.BindHandler("POST:/introspect", gft.IntrospectHandler)
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		token, err := gft.GenerateToken(ctx, gmd5.MustEncrypt("introspect_handler"), nil)
+		t.AssertNil(err)
+		client := g.Client()
+		client.SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+
+		res, err := client.Post(ctx, "/introspect", g.Map{"token": token})
+		t.AssertNil(err)
+		t.Assert(res.StatusCode, http.StatusUnauthorized)
+		res.Close()
+
+		content := client.BasicAuth("resource", "wrong").PostContent(ctx, "/introspect", g.Map{"token": token})
+		t.Assert(gjson.New(content).Get("error"), "invalid_client")
+
+		content = client.BasicAuth("resource", "secret").PostContent(ctx, "/introspect", g.Map{"token": token})
+		t.Assert(gjson.New(content).Get("active"), true)
+
+		content = client.PostContent(ctx, "/introspect", g.Map{
+			"token":         token,
+			"client_id":     "resource",
+			"client_secret": "secret",
+		})
+		t.Assert(gjson.New(content).Get("active"), true)
+	})
+}
+
+func Test_RevokeAndLogout(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_revoke_"),
+		gftoken.WithClientCredentials("resource", "secret"),
+		gftoken.WithLogoutResponse(g.Map{"code": 0, "message": "bye"}),
+		gftoken.WithGCache(),
+	)
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		group.POST("/revoke", gft.RevokeHandler)
+		gft.Middleware(group)
+		group.POST("/logout", gft.LogoutHandler)
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		client := g.Client()
+		client.SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+
+		token, err := gft.GenerateToken(ctx, gmd5.MustEncrypt("revoke"), nil)
+		t.AssertNil(err)
+		res, err := client.Post(ctx, "/revoke", g.Map{"token": token})
+		t.AssertNil(err)
+		t.Assert(res.StatusCode, http.StatusUnauthorized)
+		res.Close()
+		res, err = client.BasicAuth("resource", "secret").Post(ctx, "/revoke", g.Map{"token": token})
+		t.AssertNil(err)
+		t.Assert(res.StatusCode, http.StatusOK)
+		res.Close()
+		t.Assert(gft.IsEffective(ctx, token), false)
+		// 已撤销的token再次撤销同样返回成功
+		res, err = client.BasicAuth("resource", "secret").Post(ctx, "/revoke", g.Map{"token": token})
+		t.AssertNil(err)
+		t.Assert(res.StatusCode, http.StatusOK)
+		res.Close()
+
+		token, err = gft.GenerateToken(ctx, gmd5.MustEncrypt("logout"), nil)
+		t.AssertNil(err)
+		content := client.HeaderRaw("Authorization: Bearer "+token).PostContent(ctx, "/logout")
+		t.Assert(gjson.New(content).Get("message"), "bye")
+		t.Assert(gft.IsEffective(ctx, token), false)
+
+		// 撤销刷新令牌，签发该刷新令牌的会话同时失效
+		for _, hint := range []string{"refresh_token", "access_token", ""} {
+			login, err := gft.Login(ctx, "revoke_refresh", nil)
+			t.AssertNil(err)
+			res, err = client.BasicAuth("resource", "secret").Post(ctx, "/revoke", g.Map{
+				"token":           login.RefreshToken,
+				"token_type_hint": hint,
+			})
+			t.AssertNil(err)
+			t.Assert(res.StatusCode, http.StatusOK)
+			res.Close()
+			_, err = gft.ExchangeRefreshToken(ctx, login.RefreshToken)
+			t.AssertNE(err, nil)
+			t.Assert(gft.IsEffective(ctx, login.Token), false)
+		}
+		// 类型提示与实际类型不符时同样撤销
+		login, err := gft.Login(ctx, "revoke_hint", nil)
+		t.AssertNil(err)
+		res, err = client.BasicAuth("resource", "secret").Post(ctx, "/revoke", g.Map{
+			"token":           login.Token,
+			"token_type_hint": "refresh_token",
+		})
+		t.AssertNil(err)
+		res.Close()
+		t.Assert(gft.IsEffective(ctx, login.Token), false)
+	})
+}
+
+func Test_LogoutHandler(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_logout_"),
+		gftoken.WithLoginCookie(true),
+		gftoken.WithGCache(),
+	)
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		gft.Middleware(group)
+		group.POST("/logout", gft.LogoutHandler)
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		client := g.Client()
+		client.SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+
+		login, err := gft.Login(ctx, "logout", nil)
+		t.AssertNil(err)
+		res, err := client.Cookie(g.MapStrStr{
+			gftoken.TokenCookieName:        login.Token,
+			gftoken.RefreshTokenCookieName: login.RefreshToken,
+		}).Post(ctx, "/logout")
+		t.AssertNil(err)
+		j := gjson.New(res.ReadAllString())
+		cookies := res.Cookies()
+		res.Close()
+		t.Assert(j.Get("code"), 0)
+		t.Assert(j.Get("message"), "退出成功")
+		t.Assert(gft.IsEffective(ctx, login.Token), false)
+		_, err = gft.ExchangeRefreshToken(ctx, login.RefreshToken)
+		t.AssertNE(err, nil)
+		// token及刷新令牌的cookie均被清除
+		cleared := make(map[string]bool)
+		for _, cookie := range cookies {
+			cleared[cookie.Name] = cookie.Value == "" && cookie.Expires.Before(time.Now())
+		}
+		t.Assert(cleared[gftoken.TokenCookieName], true)
+		t.Assert(cleared[gftoken.RefreshTokenCookieName], true)
+	})
+}
+
+func Test_LoginHandler(t *testing.T) {
+	var failed = gtype.NewString()
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_login_"),
+		gftoken.WithLoginCookie(true),
+		gftoken.WithHook(gftoken.HookFuncs{
+			AuthFailed: func(ctx context.Context, e *gftoken.Event) {
+				failed.Set(e.Account)
+			},
+		}),
+		gftoken.WithGCache(),
+	)
+	verifier := gftoken.CredentialVerifierFunc(func(ctx context.Context, username, password string) (string, interface{}, error) {
+		if username != "admin" || password != "123456" {
+			return "", nil, gerror.New("invalid password")
+		}
+		return "1", scopedUser{Name: username}, nil
+	})
+	secure := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_login_secure_"),
+		gftoken.WithLoginCookie(true, true),
+		gftoken.WithGCache(),
+	)
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		group.POST("/login", gft.LoginHandler(verifier))
+		group.POST("/secure/login", secure.LoginHandler(verifier))
+		group.POST("/refresh", gft.RefreshHandler)
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		client := g.Client()
+		client.SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+
+		content := client.PostContent(ctx, "/login", g.Map{"username": "admin", "password": "wrong"})
+		t.Assert(gjson.New(content).Get("code"), gftoken.FailedAuthCode)
+		t.Assert(failed.Val(), "admin")
+
+		res, err := client.Post(ctx, "/login", g.Map{"username": "admin", "password": "123456"})
+		t.AssertNil(err)
+		j := gjson.New(res.ReadAllString())
+		res.Close()
+		t.Assert(j.Get("code"), 0)
+		t.Assert(j.Get("data.tokenType"), "Bearer")
+		t.AssertGT(j.Get("data.expiresAt").Int64(), time.Now().Unix())
+		t.Assert(res.GetCookie(gftoken.TokenCookieName), j.Get("data.token"))
+		token := j.Get("data.token").String()
+		refreshToken := j.Get("data.refreshToken").String()
+		t.Assert(gft.Introspect(ctx, token).Sub, gmd5.MustEncrypt("1"))
+		t.Assert(gft.Introspect(ctx, token).Scope, "read:user write:user")
+
+		j = gjson.New(client.PostContent(ctx, "/refresh", g.Map{"refresh_token": refreshToken}))
+		t.Assert(j.Get("code"), 0)
+		t.AssertNE(j.Get("data.token"), token)
+		t.Assert(gft.IsEffective(ctx, token), false)
+		t.Assert(gft.Introspect(ctx, j.Get("data.token").String()).Scope, "read:user write:user")
+		// 刷新令牌只能使用一次
+		content = client.PostContent(ctx, "/refresh", g.Map{"refresh_token": refreshToken})
+		t.Assert(gjson.New(content).Get("code"), gftoken.FailedAuthCode)
+
+		// 删除token时刷新令牌同时失效
+		t.AssertNil(gft.RemoveToken(ctx, j.Get("data.token").String()))
+		_, err = gft.ExchangeRefreshToken(ctx, j.Get("data.refreshToken").String())
+		t.AssertNE(err, nil)
+	})
+
+	// 在代理处终止TLS时cookie仍设置Secure
+	gtest.C(t, func(t *gtest.T) {
+		client := g.Client()
+		client.SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		for path, want := range map[string]bool{"/login": false, "/secure/login": true} {
+			res, err := client.Post(ctx, path, g.Map{"username": "admin", "password": "123456"})
+			t.AssertNil(err)
+			cookies := res.Cookies()
+			res.Close()
+			t.Assert(len(cookies), 2)
+			for _, cookie := range cookies {
+				t.Assert(cookie.Secure, want)
+			}
+		}
+	})
+}
+
+func Test_RefreshTokenReplaced(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_refresh_replaced_"),
+			gftoken.WithGCache(),
+		)
+		first, err := gft.Login(ctx, "replaced", nil)
+		t.AssertNil(err)
+		second, err := gft.Login(ctx, "replaced", nil)
+		t.AssertNil(err)
+		// 被顶替会话的刷新令牌不能再换取新的会话
+		_, err = gft.ExchangeRefreshToken(ctx, first.RefreshToken)
+		t.AssertNE(err, nil)
+		t.AssertNil(gft.CheckToken(ctx, second.Token))
+		res, err := gft.ExchangeRefreshToken(ctx, second.RefreshToken)
+		t.AssertNil(err)
+		t.AssertNil(gft.CheckToken(ctx, res.Token))
+	})
+}
+
+func Test_ExchangeRefreshToken(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_exchange_"),
+			gftoken.WithMultiLogin(true),
+			gftoken.WithGCache(),
+		)
+		res, err := gft.Login(ctx, "exchange", nil)
+		t.AssertNil(err)
+		// 并发换取同一个刷新令牌只有一个成功
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			results []*gftoken.LoginResult
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if v, err := gft.ExchangeRefreshToken(ctx, res.RefreshToken); err == nil {
+					mu.Lock()
+					results = append(results, v)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		t.Assert(len(results), 1)
+		// 多点登录时携带刷新令牌的会话同时失效
+		t.Assert(errors.Is(gft.CheckToken(ctx, res.Token), gftoken.ErrTokenRevoked), true)
+		t.AssertNil(gft.CheckToken(ctx, results[0].Token))
+	})
+}
+
+func Test_LoginThrottle(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_throttle_"),
+			gftoken.WithLoginThrottle(gftoken.ThrottleConfig{
+				MaxAttempts:     3,
+				LockDuration:    10,
+				MaxLockDuration: 15,
+			}),
+			gftoken.WithDistConfig(&adapter.Config{Dir: gfile.Temp(guid.S())}),
+		)
+		for i := 0; i < 2; i++ {
+			t.AssertNil(gft.RecordLoginFailure(ctx, "admin", "127.0.0.1"))
+		}
+		locked, _, err := gft.CheckThrottle(ctx, "admin", "127.0.0.1")
+		t.AssertNil(err)
+		t.Assert(locked, false)
+		lockout, err := gft.GetLockout(ctx, gftoken.ThrottleAccount, "admin")
+		t.AssertNil(err)
+		t.Assert(lockout.Failures, 2)
+
+		t.AssertNil(gft.RecordLoginFailure(ctx, "admin", "127.0.0.1"))
+		locked, retryAfter, err := gft.CheckThrottle(ctx, "admin", "127.0.0.2")
+		t.AssertNil(err)
+		t.Assert(locked, true)
+		t.AssertLE(retryAfter, 10*time.Second)
+		lockout, err = gft.GetLockout(ctx, gftoken.ThrottleIP, "127.0.0.1")
+		t.AssertNil(err)
+		t.Assert(lockout.Locked(), true)
+		t.Assert(lockout.Failures, 0)
+
+		// 再次锁定时锁定时长翻倍，但不超过最长锁定时长
+		for i := 0; i < 3; i++ {
+			t.AssertNil(gft.RecordLoginFailure(ctx, "admin", ""))
+		}
+		lockout, err = gft.GetLockout(ctx, gftoken.ThrottleAccount, "admin")
+		t.AssertNil(err)
+		t.Assert(lockout.Locks, 2)
+		t.AssertGT(lockout.LockedUntil, time.Now().Unix()+10)
+
+		t.AssertNil(gft.ResetLockout(ctx, gftoken.ThrottleAccount, "admin"))
+		locked, _, err = gft.CheckThrottle(ctx, "admin", "")
+		t.AssertNil(err)
+		t.Assert(locked, false)
+	})
+}
+
+func Test_LoginThrottleConcurrent(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_throttle_concurrent_"),
+			gftoken.WithLoginThrottle(gftoken.ThrottleConfig{MaxAttempts: 5}),
+			gftoken.WithDistConfig(&adapter.Config{Dir: gfile.Temp(guid.S())}),
+		)
+		account := guid.S()
+		for i := 0; i < 4; i++ {
+			t.AssertNil(gft.RecordLoginFailure(ctx, account, ""))
+		}
+		// 同时达到上限的失败请求只锁定一次
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				t.AssertNil(gft.RecordLoginFailure(ctx, account, ""))
+			}()
+		}
+		wg.Wait()
+		lockout, err := gft.GetLockout(ctx, gftoken.ThrottleAccount, account)
+		t.AssertNil(err)
+		t.Assert(lockout.Locked(), true)
+		t.Assert(lockout.Locks, 1)
+	})
+}
+
+func Test_TrustedProxies(t *testing.T) {
+	verifier := gftoken.CredentialVerifierFunc(func(ctx context.Context, username, password string) (string, interface{}, error) {
+		return "", nil, gerror.New("invalid password")
+	})
+	newServer := func(gft *gftoken.GfToken) *ghttp.Server {
+		s := g.Server(guid.S())
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.POST("/login", gft.LoginHandler(verifier))
+		})
+		s.SetDumpRouterMap(false)
+		s.Start()
+		time.Sleep(100 * time.Millisecond)
+		return s
+	}
+	throttle := gftoken.WithLoginThrottle(gftoken.ThrottleConfig{MaxAttempts: 2})
+
+	// 未设置可信代理时忽略X-Forwarded-For，更换请求头不能绕过按IP的限制
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(gftoken.WithCacheKey("test_proxy_none_"), throttle, gftoken.WithGCache())
+		s := newServer(gft)
+		defer s.Shutdown()
+		client := g.Client().SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		for i := 0; i < 2; i++ {
+			client.Header(g.MapStrStr{"X-Forwarded-For": fmt.Sprintf("10.0.0.%d", i)}).
+				PostContent(ctx, "/login", g.Map{"username": fmt.Sprintf("user%d", i), "password": "wrong"})
+		}
+		lockout, err := gft.GetLockout(ctx, gftoken.ThrottleIP, "127.0.0.1")
+		t.AssertNil(err)
+		t.Assert(lockout.Locked(), true)
+		lockout, err = gft.GetLockout(ctx, gftoken.ThrottleIP, "10.0.0.0")
+		t.AssertNil(err)
+		t.Assert(lockout.Failures, 0)
+	})
+
+	// 直连地址为可信代理时使用X-Forwarded-For中最右侧的非代理地址
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_proxy_trusted_"),
+			gftoken.WithTrustedProxies("127.0.0.1", "192.168.0.0/16"),
+			throttle,
+			gftoken.WithGCache(),
+		)
+		s := newServer(gft)
+		defer s.Shutdown()
+		client := g.Client().SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		client.Header(g.MapStrStr{"X-Forwarded-For": "1.1.1.1, 10.0.0.1, 192.168.1.1"}).
+			PostContent(ctx, "/login", g.Map{"username": "proxy", "password": "wrong"})
+		lockout, err := gft.GetLockout(ctx, gftoken.ThrottleIP, "10.0.0.1")
+		t.AssertNil(err)
+		t.Assert(lockout.Failures, 1)
+		lockout, err = gft.GetLockout(ctx, gftoken.ThrottleIP, "127.0.0.1")
+		t.AssertNil(err)
+		t.Assert(lockout.Failures, 0)
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		_, err := gftoken.NewGfTokenE(gftoken.WithTrustedProxies("not-an-ip"))
+		t.AssertNE(err, nil)
+	})
+}
+
+func Test_RateLimitMiddleware(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_ratelimit_"),
+		gftoken.WithMultiLogin(true),
+		gftoken.WithGCache(),
+	)
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		gft.Middleware(group)
+		group.Middleware(gft.RateLimitMiddleware(gftoken.RateLimitConfig{
+			Default: gftoken.RateLimit{Limit: 100, Window: 60},
+			Routes: []gftoken.RouteRateLimit{
+				{Path: "POST:/limited/*", RateLimit: gftoken.RateLimit{Limit: 2, Window: 60}},
+			},
+		}))
+		group.ALL("/limited/a", func(r *ghttp.Request) { r.Response.Write("ok") })
+		group.ALL("/limited/b", func(r *ghttp.Request) { r.Response.Write("ok") })
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			key    = gmd5.MustEncrypt("ratelimit")
+			client = g.Client()
+		)
+		client.SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		// 多点登录的两个会话共用同一用户的配额
+		token1, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		token2, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+
+		res, err := client.HeaderRaw("Authorization: Bearer "+token1).Post(ctx, "/limited/a")
+		t.AssertNil(err)
+		t.Assert(res.ReadAllString(), "ok")
+		t.Assert(res.Header.Get("X-RateLimit-Limit"), 2)
+		t.Assert(res.Header.Get("X-RateLimit-Remaining"), 1)
+		res.Close()
+		t.Assert(client.HeaderRaw("Authorization: Bearer "+token2).PostContent(ctx, "/limited/b"), "ok")
+
+		res, err = client.HeaderRaw("Authorization: Bearer "+token1).Post(ctx, "/limited/a")
+		t.AssertNil(err)
+		t.Assert(res.StatusCode, http.StatusTooManyRequests)
+		t.AssertNE(res.Header.Get("Retry-After"), "")
+		res.Close()
+
+		// 其他请求方法使用默认规则
+		res, err = client.HeaderRaw("Authorization: Bearer "+token1).Get(ctx, "/limited/a")
+		t.AssertNil(err)
+		t.Assert(res.ReadAllString(), "ok")
+		t.Assert(res.Header.Get("X-RateLimit-Limit"), 100)
+		res.Close()
+	})
+
+	// 限流规则无效时返回错误
+	gtest.C(t, func(t *gtest.T) {
+		for _, limit := range []gftoken.RateLimit{{Limit: 10, Window: 0}, {Limit: 0, Window: 60}, {Limit: 10, Window: -1}} {
+			_, err := gft.Allow(ctx, "invalid", limit)
+			t.AssertNE(err, nil)
+		}
+		res, err := gft.Allow(ctx, "valid", gftoken.RateLimit{Limit: 1, Window: 60})
+		t.AssertNil(err)
+		t.Assert(res.Allowed, true)
+	})
+}
+
+func Test_SessionLimit(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_session_evict_"),
+			gftoken.WithMultiLogin(true),
+			gftoken.WithSessionLimit(gftoken.SessionLimit{
+				Max:       3,
+				DeviceMax: map[string]int{"web": 1},
+			}),
+			gftoken.WithDistConfig(&adapter.Config{Dir: gfile.Temp(guid.S())}),
+		)
+		var (
+			key    = gmd5.MustEncrypt("session_evict")
+			web    = gftoken.ContextWithDevice(ctx, "web")
+			mobile = gftoken.ContextWithDevice(ctx, "mobile")
+		)
+		web1, err := gft.GenerateToken(web, key, nil)
+		t.AssertNil(err)
+		web2, err := gft.GenerateToken(web, key, nil)
+		t.AssertNil(err)
+		// 同一设备类型只保留最新的会话
+		t.Assert(gft.IsEffective(ctx, web1), false)
+		t.Assert(gft.IsEffective(ctx, web2), true)
+
+		mobile1, err := gft.GenerateToken(mobile, key, nil)
+		t.AssertNil(err)
+		mobile2, err := gft.GenerateToken(mobile, key, nil)
+		t.AssertNil(err)
+		mobile3, err := gft.GenerateToken(mobile, key, nil)
+		t.AssertNil(err)
+		// 超出总数时踢出最早登录的会话
+		t.Assert(gft.IsEffective(ctx, web2), false)
+		t.Assert(gft.IsEffective(ctx, mobile1), true)
+		t.Assert(gft.IsEffective(ctx, mobile2), true)
+		t.Assert(gft.IsEffective(ctx, mobile3), true)
+
+		// 注销后释放会话配额
+		t.AssertNil(gft.RemoveToken(ctx, mobile3))
+		_, err = gft.GenerateToken(web, key, nil)
+		t.AssertNil(err)
+		t.Assert(gft.IsEffective(ctx, mobile1), true)
+	})
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_session_reject_"),
+			gftoken.WithMultiLogin(true),
+			gftoken.WithSessionLimit(gftoken.SessionLimit{
+				Max:    1,
+				Policy: gftoken.SessionRejectNew,
+			}),
+			gftoken.WithGCache(),
+		)
+		key := gmd5.MustEncrypt("session_reject")
+		token, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		_, err = gft.GenerateToken(ctx, key, nil)
+		t.Assert(errors.Is(err, gftoken.ErrSessionLimitExceeded), true)
+		t.Assert(gft.IsEffective(ctx, token), true)
+	})
+	// 共用缓存的多个实例并发登录
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			dist = adapter.NewDist()
+			key  = gmd5.MustEncrypt("session_concurrent")
+			gfts = make([]*gftoken.GfToken, 2)
+		)
+		for i := range gfts {
+			gfts[i] = gftoken.NewGfToken(
+				gftoken.WithCacheKey("test_session_concurrent_"),
+				gftoken.WithMultiLogin(true),
+				gftoken.WithSessionLimit(gftoken.SessionLimit{
+					Max:    2,
+					Policy: gftoken.SessionRejectNew,
+				}),
+				gftoken.WithDist(dist),
+			)
+		}
+		var (
+			wg      sync.WaitGroup
+			success int32
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(gft *gftoken.GfToken) {
+				defer wg.Done()
+				if _, err := gft.GenerateToken(ctx, key, nil); err == nil {
+					atomic.AddInt32(&success, 1)
+				}
+			}(gfts[i%2])
+		}
+		wg.Wait()
+		t.Assert(success, 2)
+	})
+	// 会话索引锁只能由持有者释放，锁过期后被其他实例获取时不会被误删
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			dist    = adapter.NewDist()
+			lockKey = "test_session_lock_" + guid.S()
+		)
+		ok, err := dist.SetNX(ctx, lockKey, "owner1", time.Second)
+		t.AssertNil(err)
+		t.Assert(ok, true)
+		ok, err = dist.SetNX(ctx, lockKey, "owner2", time.Second)
+		t.AssertNil(err)
+		t.Assert(ok, false)
+		time.Sleep(2100 * time.Millisecond)
+		ok, err = dist.SetNX(ctx, lockKey, "owner2", time.Minute)
+		t.AssertNil(err)
+		t.Assert(ok, true)
+		ok, err = dist.RemoveIfEqual(ctx, lockKey, "owner1")
+		t.AssertNil(err)
+		t.Assert(ok, false)
+		v, err := dist.Get(ctx, lockKey)
+		t.AssertNil(err)
+		t.Assert(v.String(), "owner2")
+		ok, err = dist.RemoveIfEqual(ctx, lockKey, "owner2")
+		t.AssertNil(err)
+		t.Assert(ok, true)
+		ok, err = dist.Contains(ctx, lockKey)
+		t.AssertNil(err)
+		t.Assert(ok, false)
+	})
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			dist = adapter.NewDist()
+			key  = gmd5.MustEncrypt(guid.S())
+			gft  = gftoken.NewGfToken(
+				gftoken.WithCacheKey("test_session_lock_"),
+				gftoken.WithMultiLogin(true),
+				gftoken.WithSessionLimit(gftoken.SessionLimit{Max: 2}),
+				gftoken.WithDist(dist),
+			)
+			lockKey = "test_session_lock_sessions_" + key[:16] + "_lock"
+		)
+		// 其他实例持有锁时等待，不会获取或删除其他实例的锁
+		t.AssertNil(dist.Set(ctx, lockKey, "other", time.Minute))
+		timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		_, err := gft.GenerateToken(timeoutCtx, key, nil)
+		t.AssertNE(err, nil)
+		v, err := dist.Get(ctx, lockKey)
+		t.AssertNil(err)
+		t.Assert(v.String(), "other")
+		// 锁释放后正常登录，登录完成后释放自己持有的锁
+		_, err = dist.Remove(ctx, lockKey)
+		t.AssertNil(err)
+		_, err = gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		ok, err := dist.Contains(ctx, lockKey)
+		t.AssertNil(err)
+		t.Assert(ok, false)
+	})
+	// 会话刷新时延长会话索引的有效期
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_session_touch_"),
+			gftoken.WithTimeoutAndMaxRefresh(2, 2),
+			gftoken.WithMultiLogin(true),
+			gftoken.WithSessionLimit(gftoken.SessionLimit{Max: 1}),
+			gftoken.WithGCache(),
+		)
+		key := gmd5.MustEncrypt("session_touch")
+		token1, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		time.Sleep(3 * time.Second)
+		t.Assert(gft.IsEffective(ctx, token1), true)
+		// 原索引已过期，刷新后的会话仍计入数量限制
+		time.Sleep(1500 * time.Millisecond)
+		token2, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		t.Assert(gft.IsEffective(ctx, token1), false)
+		t.Assert(gft.IsEffective(ctx, token2), true)
+	})
+}
+
+func Test_DeviceInfo(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_device_"),
+		gftoken.WithMultiLogin(true),
+		gftoken.WithDeviceTracking(true),
+		gftoken.WithDeviceBinding(true),
+		gftoken.WithGCache(),
+	)
+	key := gmd5.MustEncrypt("device")
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		group.GET("/login", func(r *ghttp.Request) {
+			token, err := gft.GenerateToken(r.GetCtx(), key, nil)
+			if err != nil {
+				r.Response.WriteStatus(http.StatusInternalServerError, err.Error())
+				return
+			}
+			r.Response.Write(token)
+		})
+		gft.Middleware(group)
+		group.GET("/user", func(r *ghttp.Request) { r.Response.Write("ok") })
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		const (
+			iphone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148"
+			windows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0"
+		)
+		client := g.Client()
+		client.SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		mobileToken := client.SetAgent(iphone).GetContent(ctx, "/login")
+		webToken := client.SetAgent(windows).Header(g.MapStrStr{gftoken.DeviceNameHeader: "office"}).GetContent(ctx, "/login")
+
+		device, err := gft.GetDevice(ctx, mobileToken)
+		t.AssertNil(err)
+		t.Assert(device.DeviceType, gftoken.DeviceMobile)
+		t.Assert(device.DeviceName, "iPhone")
+		t.Assert(device.UserAgent, iphone)
+		t.Assert(device.ClientIp, "127.0.0.1")
+		t.AssertGT(device.LoginAt, 0)
+
+		device, err = gft.GetDevice(ctx, webToken)
+		t.AssertNil(err)
+		t.Assert(device.DeviceName, "office")
+
+		sessions, err := gft.GetSessions(ctx, key)
+		t.AssertNil(err)
+		t.Assert(len(sessions), 2)
+		t.Assert(sessions[1].Device.DeviceType, gftoken.DeviceWeb)
+		t.Assert(sessions[1].Device.DeviceName, "office")
+		t.AssertNE(sessions[1].Id, "")
+		// 与 Login 相同，不足32位的用户标识按md5处理
+		sessions, err = gft.GetSessions(ctx, "device")
+		t.AssertNil(err)
+		t.Assert(len(sessions), 2)
+
+		// 设备指纹不一致时拒绝请求
+		t.Assert(client.SetAgent(iphone).HeaderRaw("Authorization: Bearer "+mobileToken).GetContent(ctx, "/user"), "ok")
+		t.AssertNE(client.SetAgent(windows).HeaderRaw("Authorization: Bearer "+mobileToken).GetContent(ctx, "/user"), "ok")
+	})
+	// 未记录会话列表时多点登录不维护会话索引
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_device_untracked_"),
+			gftoken.WithMultiLogin(true),
+			gftoken.WithGCache(),
+		)
+		token, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		t.Assert(gft.IsEffective(ctx, token), true)
+		_, err = gft.GetSessions(ctx, key)
+		t.Assert(gerror.Code(err), gcode.CodeInvalidConfiguration)
+	})
+}
+
+func Test_SessionReplaced(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		var replaced *gftoken.SessionReplaced
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_replaced_"),
+			gftoken.WithOnSessionReplaced(func(ctx context.Context, r *gftoken.SessionReplaced) {
+				replaced = r
+			}),
+			gftoken.WithGCache(),
+		)
+		key := gmd5.MustEncrypt("replaced")
+		token1, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		t.AssertNil(replaced)
+		token2, err := gft.GenerateToken(gftoken.ContextWithDevice(ctx, gftoken.DeviceMobile), key, nil)
+		t.AssertNil(err)
+
+		t.AssertNE(replaced, nil)
+		t.Assert(replaced.UserKey, key)
+		t.Assert(replaced.Device.DeviceType, gftoken.DeviceMobile)
+		t.AssertNE(replaced.SessionId, "")
+
+		err = gft.CheckToken(ctx, token1)
+		var replacedErr *gftoken.SessionReplacedError
+		t.Assert(errors.As(err, &replacedErr), true)
+		t.Assert(replacedErr.Replaced.ReplacedAt, replaced.ReplacedAt)
+		t.Assert(err.Error(), gftoken.ErrorsSessionReplaced)
+		t.AssertNil(gft.CheckToken(ctx, token2))
+		t.Assert(gft.CheckToken(ctx, "invalid"), gftoken.ErrTokenInvalid)
+	})
+}
+
+func Test_SessionReplacedResponse(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_replaced_response_"),
+			gftoken.WithExcludePaths(g.SliceStr{"/login"}),
+			gftoken.WithGCache(),
+		)
+		key := gmd5.MustEncrypt("replaced")
+		handler := gft.HttpMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := gft.GenerateToken(r.Context(), key, nil)
+			t.AssertNil(err)
+			_, _ = w.Write([]byte(token))
+		}))
+		login := func(ip string) string {
+			r := httptest.NewRequest(http.MethodPost, "/login", nil)
+			r.Header.Set("X-Forwarded-For", ip)
+			r.Header.Set(gftoken.DeviceNameHeader, ip)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w.Body.String()
+		}
+		token := login("10.0.0.1")
+		login("10.0.0.2")
+
+		r := httptest.NewRequest(http.MethodGet, "/user", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		j := gjson.New(w.Body.String())
+		t.Assert(j.Get("message"), gftoken.ErrorsSessionReplaced)
+		t.Assert(j.Get("data.deviceName"), "10.0.0.2")
+		// 不向被顶替的客户端返回新登录的IP
+		t.Assert(j.Contains("data.clientIp"), false)
+	})
+}
+
+func Test_Hooks(t *testing.T) {
+	var (
+		events = garray.NewStrArray(true)
+		async  = make(chan *gftoken.Event, 10)
+		record = func(ctx context.Context, e *gftoken.Event) {
+			events.Append(string(e.Type) + ":" + e.UserKey)
+		}
+		gft = gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_hooks_"),
+			gftoken.WithTimeoutAndMaxRefresh(0, 60),
+			gftoken.WithHook(gftoken.HookFuncs{
+				Login:      record,
+				Refresh:    record,
+				Logout:     record,
+				AuthFailed: record,
+			}),
+			gftoken.WithAsyncHook(gftoken.HookFuncs{
+				Login: func(ctx context.Context, e *gftoken.Event) {
+					async <- e
+				},
+			}, 1),
+			gftoken.WithGCache(),
+		)
+	)
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		gft.Middleware(group)
+		group.GET("/user", func(r *ghttp.Request) { r.Response.Write("ok") })
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		client := g.Client()
+		client.SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		key := gmd5.MustEncrypt("hooks")
+		token, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		select {
+		case e := <-async:
+			t.Assert(e.Type, gftoken.EventLogin)
+			t.Assert(e.UserKey, key)
+			t.AssertNE(e.SessionId, "")
+		case <-time.After(time.Second):
+			t.Error("async hook not called")
+		}
+		// 处于刷新期的token被自动刷新
+		time.Sleep(1100 * time.Millisecond)
+		t.Assert(gft.IsEffective(ctx, token), true)
+		t.Assert(client.GetContent(ctx, "/user?token=invalid") == "ok", false)
+		t.AssertNil(gft.RemoveToken(ctx, token))
+		t.Assert(events.Slice(), g.SliceStr{
+			"login:" + key,
+			"refresh:" + key,
+			"authFailed:",
+			"logout:" + key,
+		})
+	})
+}
+
+func Test_Audit(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			dir  = gfile.Temp(guid.S())
+			path = gfile.Join(dir, "audit.log")
+			sink = gftoken.NewFileAuditSink(path, 0, 0)
+			gft  = gftoken.NewGfToken(
+				gftoken.WithServerName("auditServer"),
+				gftoken.WithCacheKey("test_audit_"),
+				gftoken.WithAudit(sink),
+				gftoken.WithGCache(),
+			)
+		)
+		defer gfile.Remove(dir)
+		key := gmd5.MustEncrypt("audit")
+		token, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		t.AssertNil(gft.RemoveToken(ctx, token))
+		// 等待异步写入完成并关闭文件
+		gft.Close()
+
+		var records []*gftoken.AuditEvent
+		for _, line := range gstr.SplitAndTrim(gfile.GetContents(path), "\n") {
+			record := new(gftoken.AuditEvent)
+			t.AssertNil(gjson.DecodeTo(line, record))
+			records = append(records, record)
+		}
+		t.Assert(len(records), 2)
+		t.Assert(records[0].Event, gftoken.EventLogin)
+		t.Assert(records[0].Server, "auditServer")
+		t.Assert(records[0].UserKey, key)
+		t.Assert(records[0].Success, true)
+		t.Assert(records[1].Event, gftoken.EventLogout)
+		t.Assert(records[1].SessionId, records[0].SessionId)
+	})
+
+	// 请求信息在请求协程中读取，异步写入不访问请求对象
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			dir  = gfile.Temp(guid.S())
+			path = gfile.Join(dir, "audit.log")
+			gft  = gftoken.NewGfToken(
+				gftoken.WithCacheKey("test_audit_login_"),
+				gftoken.WithAudit(gftoken.NewFileAuditSink(path, 0, 0)),
+				gftoken.WithGCache(),
+			)
+		)
+		defer gfile.Remove(dir)
+		verifier := gftoken.CredentialVerifierFunc(func(ctx context.Context, username, password string) (string, interface{}, error) {
+			return "", nil, gerror.New("invalid password")
+		})
+		s := g.Server(guid.S())
+		s.Group("/", func(group *ghttp.RouterGroup) {
+			group.POST("/login", gft.LoginHandler(verifier))
+		})
+		s.SetDumpRouterMap(false)
+		s.SetAccessLogEnabled(true)
+		s.Start()
+		defer s.Shutdown()
+		time.Sleep(100 * time.Millisecond)
+
+		client := g.Client().SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		for i := 0; i < 5; i++ {
+			client.PostContent(ctx, "/login", g.Map{"username": "auditor", "password": "wrong"})
+		}
+		gft.Close()
+
+		lines := gstr.SplitAndTrim(gfile.GetContents(path), "\n")
+		t.Assert(len(lines), 5)
+		record := new(gftoken.AuditEvent)
+		t.AssertNil(gjson.DecodeTo(lines[0], record))
+		t.Assert(record.Event, gftoken.EventAuthFailed)
+		t.Assert(record.Account, "auditor")
+		t.Assert(record.Path, "/login")
+		t.Assert(record.ClientIp, "127.0.0.1")
+		t.AssertNE(record.UserAgent, "")
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		dir := gfile.Temp(guid.S())
+		defer gfile.Remove(dir)
+		path := gfile.Join(dir, "audit.log")
+		sink := gftoken.NewFileAuditSink(path, 100, 2)
+		for i := 0; i < 10; i++ {
+			t.AssertNil(sink.Write(ctx, &gftoken.AuditEvent{
+				Time:   time.Now(),
+				Event:  gftoken.EventAuthFailed,
+				Reason: gftoken.ErrorsTokenInvalid,
+			}))
+		}
+		t.AssertNil(sink.Close())
+		backups, err := gfile.Glob(path + ".*")
+		t.AssertNil(err)
+		t.Assert(len(backups), 2)
+	})
+}
+
+type metricsRecorder struct {
+	records *garray.StrArray
+}
+
+func (r *metricsRecorder) ObserveOperation(server, op, outcome, reason string, duration time.Duration) {
+	r.records.Append(server + ":" + op + ":" + outcome + ":" + reason)
+}
+
+func Test_Metrics(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			recorder = &metricsRecorder{records: garray.NewStrArray(true)}
+			gft      = gftoken.NewGfToken(
+				gftoken.WithServerName("metricsServer"),
+				gftoken.WithCacheKey("test_metrics_"),
+				gftoken.WithMetrics(recorder),
+				gftoken.WithGCache(),
+			)
+		)
+		key := gmd5.MustEncrypt("metrics")
+		token, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		t.Assert(gft.IsEffective(ctx, token), true)
+		t.Assert(gft.IsEffective(ctx, "invalid"), false)
+		t.Assert(recorder.records.Contains("metricsServer:generate:success:"), true)
+		t.Assert(recorder.records.Contains("metricsServer:validate:success:"), true)
+		t.Assert(recorder.records.Contains("metricsServer:validate:failure:invalid"), true)
+		t.Assert(recorder.records.Contains("metricsServer:cache_get:success:"), true)
+
+		// 统计会话数不读取会话，不计入缓存操作指标
+		records := recorder.records.Len()
+		sessions, err := gft.CountSessions(ctx)
+		t.AssertNil(err)
+		t.Assert(sessions, 1)
+		t.Assert(recorder.records.Len(), records)
+
+		t.AssertNil(gft.RemoveToken(ctx, token))
+		t.Assert(recorder.records.Contains("metricsServer:remove:success:"), true)
+		sessions, err = gft.CountSessions(ctx)
+		t.AssertNil(err)
+		t.Assert(sessions, 0)
+		// 刷新令牌等内部数据不计入会话数
+		_, err = gft.Login(ctx, "metrics", nil)
+		t.AssertNil(err)
+		sessions, err = gft.CountSessions(ctx)
+		t.AssertNil(err)
+		t.Assert(sessions, 1)
+	})
+}
+
+func Test_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(ctx)
+	otel.SetTracerProvider(provider)
+
+	gft := gftoken.NewGfToken(
+		gftoken.WithServerName("traceServer"),
+		gftoken.WithCacheKey("test_trace_"),
+		gftoken.WithTimeoutAndMaxRefresh(0, 60),
+		gftoken.WithTracing(),
+		gftoken.WithGCache(),
+	)
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		gft.Middleware(group)
+		group.GET("/user", func(r *ghttp.Request) { r.Response.Write("ok") })
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		client := g.Client()
+		client.SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		token, err := gft.GenerateToken(ctx, gmd5.MustEncrypt("trace"), nil)
+		t.AssertNil(err)
+		time.Sleep(1100 * time.Millisecond)
+		t.Assert(client.GetContent(ctx, "/user?token="+gurl.Encode(token)), "ok")
+
+		names := garray.NewStrArray()
+		for _, span := range recorder.Ended() {
+			names.Append(span.Name())
+			for _, attr := range span.Attributes() {
+				t.Assert(strings.Contains(attr.Value.Emit(), token), false)
+				if span.Name() == "gftoken.authMiddleware" && attr.Key == "gftoken.refreshed" {
+					t.Assert(attr.Value.AsBool(), true)
+				}
+			}
+		}
+		for _, name := range []string{
+			"gftoken.authMiddleware", "gftoken.decrypt", "gftoken.cache.get",
+			"gftoken.cache.set", "gftoken.jwt.parse", "gftoken.jwt.sign",
+		} {
+			t.Assert(names.Contains(name), true)
+		}
+	})
+}
+
+func Test_NewGfTokenE(t *testing.T) {
+	var (
+		signingKey = "0123456789abcdef0123456789abcdef"
+		encryptKey = []byte("abcdef0123456789abcdef0123456789")
+	)
+	gtest.C(t, func(t *gtest.T) {
+		_, err := gftoken.NewGfTokenE()
+		t.AssertNE(err, nil)
+		t.Assert(gerror.Code(err), gcode.CodeInvalidConfiguration)
+
+		gft, err := gftoken.NewGfTokenE(gftoken.WithDefaultSecrets())
+		t.AssertNil(err)
+		t.AssertNE(gft, nil)
+	})
+	gtest.C(t, func(t *gtest.T) {
+		for _, opts := range [][]gftoken.OptionFunc{
+			{gftoken.WithUserJwt(signingKey)},
+			{gftoken.WithEncryptKey(encryptKey)},
+			{gftoken.WithUserJwt("short"), gftoken.WithEncryptKey(encryptKey)},
+			{gftoken.WithUserJwt(signingKey), gftoken.WithEncryptKey([]byte("short"))},
+			{gftoken.WithDefaultSecrets(), gftoken.WithTimeout(0)},
+			{gftoken.WithDefaultSecrets(), gftoken.WithTimeoutAndMaxRefresh(10, 20)},
+			{gftoken.WithDefaultSecrets(), gftoken.WithCacheKey("")},
+			{gftoken.WithDefaultSecrets(), gftoken.WithSessionLimit(gftoken.SessionLimit{Max: 1})},
+		} {
+			_, err := gftoken.NewGfTokenE(opts...)
+			t.AssertNE(err, nil)
+		}
+	})
+	// 各实例使用独立缓存
+	gtest.C(t, func(t *gtest.T) {
+		opts := []gftoken.OptionFunc{
+			gftoken.WithUserJwt(signingKey),
+			gftoken.WithEncryptKey(encryptKey),
+		}
+		gft1, err := gftoken.NewGfTokenE(opts...)
+		t.AssertNil(err)
+		gft2, err := gftoken.NewGfTokenE(opts...)
+		t.AssertNil(err)
+		token, err := gft1.GenerateToken(ctx, gmd5.MustEncrypt("isolated"), nil)
+		t.AssertNil(err)
+		t.Assert(gft1.IsEffective(ctx, token), true)
+		t.Assert(gft2.IsEffective(ctx, token), false)
+	})
+}
+
+func Test_NewFromConfig(t *testing.T) {
+	adapterFile, ok := g.Cfg().GetAdapter().(*gcfg.AdapterFile)
+	if !ok {
+		t.Skip("config adapter is not file adapter")
+	}
+	keyFile := gfile.Temp(guid.S())
+	defer gfile.Remove(keyFile)
+	t.Setenv("GFTOKEN_TEST_JWT_KEY", "0123456789abcdef0123456789abcdef")
+	adapterFile.SetContent(fmt.Sprintf(`
+token:
+  default:
+    serverName: "configServer"
+    cacheKey: "test_config_"
+    timeout: 3600
+    maxRefresh: 0
+    multiLogin: true
+    excludePaths: ["/login", "/public/*"]
+    signingKey: "env:GFTOKEN_TEST_JWT_KEY"
+    encryptKey: "file:%s"
+    cache:
+      adapter: "memory"
+  missingKey:
+    signingKey: "env:GFTOKEN_TEST_MISSING"
+  badCache:
+    allowDefaultSecrets: true
+    cache:
+      adapter: "unknown"
+`, keyFile))
+	defer adapterFile.ClearContent()
+
+	gtest.C(t, func(t *gtest.T) {
+		t.AssertNil(gfile.PutContents(keyFile, "abcdef0123456789abcdef0123456789\n"))
+		gft, err := gftoken.NewFromConfig(ctx, "token.default")
+		t.AssertNil(err)
+		t.Assert(gft.ServerName, "configServer")
+		t.Assert(gft.CacheKey, "test_config_")
+		t.Assert(gft.Timeout, 3600)
+		t.Assert(gft.MaxRefresh, 0)
+		t.Assert(gft.MultiLogin, true)
+		t.Assert(gft.ExcludePaths, g.SliceStr{"/login", "/public/*"})
+		t.Assert(gft.EncryptKey, []byte("abcdef0123456789abcdef0123456789"))
+		token, err := gft.GenerateToken(ctx, gmd5.MustEncrypt("config"), nil)
+		t.AssertNil(err)
+		t.Assert(gft.IsEffective(ctx, token), true)
+
+		// 同一配置返回同一实例
+		same, err := gftoken.NewFromConfig(ctx, "token.default")
+		t.AssertNil(err)
+		t.Assert(same == gft, true)
+	})
+	gtest.C(t, func(t *gtest.T) {
+		for _, pattern := range []string{"token.missingKey", "token.badCache", "token.notExist"} {
+			gft, err := gftoken.NewFromConfig(ctx, pattern)
+			t.AssertNE(err, nil)
+			t.AssertNil(gft)
+		}
+	})
+}
+
+func Test_Reload(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		gft, err := gftoken.NewGfTokenE(
+			gftoken.WithCacheKey("test_reload_"),
+			gftoken.WithTimeoutAndMaxRefresh(60, 60),
+			gftoken.WithUserJwt("0123456789abcdef0123456789abcdef"),
+			gftoken.WithEncryptKey([]byte("abcdef0123456789abcdef0123456789")),
+		)
+		t.AssertNil(err)
+		oldToken, err := gft.GenerateToken(ctx, gmd5.MustEncrypt("reload_old"), nil)
+		t.AssertNil(err)
+
+		// 并发校验的同时热更新
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					if !gft.IsEffective(ctx, oldToken) {
+						t.Error("token invalid during reload")
+					}
+				}
+			}()
+		}
+		conf := *gft.Current()
+		conf.Timeout = 120
+		conf.ExcludePaths = g.SliceStr{"/public/*"}
+		conf.SigningKey = []byte("fedcba9876543210fedcba9876543210")
+		conf.EncryptKey = []byte("9876543210abcdef9876543210abcdef")
+		t.AssertNil(gft.Reload(conf))
+		wg.Wait()
+
+		t.Assert(gft.Current().Timeout, 120)
+		t.Assert(gft.AuthPath("/public/index"), false)
+		t.Assert(len(gft.Current().PrevSigningKeys), 1)
+		t.Assert(len(gft.Current().PrevEncryptKeys), 1)
+		// 轮换前签发的token继续有效，新token使用新key
+		t.Assert(gft.IsEffective(ctx, oldToken), true)
+		newToken, err := gft.GenerateToken(ctx, gmd5.MustEncrypt("reload_new"), nil)
+		t.AssertNil(err)
+		t.Assert(gft.IsEffective(ctx, newToken), true)
+
+		// 非法配置不会替换快照
+		conf = *gft.Current()
+		conf.MaxRefresh = 1000
+		t.AssertNE(gft.Reload(conf), nil)
+		t.Assert(gft.Current().MaxRefresh, 60)
+
+		// 并发的部分更新不会相互覆盖
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				t.AssertNil(gft.ReloadFunc(func(s *gftoken.Snapshot) error {
+					s.Timeout++
+					return nil
+				}))
+			}()
+		}
+		wg.Wait()
+		t.Assert(gft.Current().Timeout, 140)
+		t.Assert(len(gft.Current().PrevSigningKeys), 1)
+	})
+
+	adapterFile, ok := g.Cfg().GetAdapter().(*gcfg.AdapterFile)
+	if !ok {
+		return
+	}
+	gtest.C(t, func(t *gtest.T) {
+		gft := gftoken.NewGfToken(gftoken.WithCacheKey("test_reload_config_"), gftoken.WithGCache())
+		adapterFile.SetContent(`
+token:
+  reload:
+    timeout: 300
+    maxRefresh: 100
+    excludePaths: ["/login"]
+`)
+		defer adapterFile.ClearContent()
+		t.AssertNil(gft.ReloadConfig(ctx, "token.reload"))
+		t.Assert(gft.Current().Timeout, 300)
+		t.Assert(gft.Current().MaxRefresh, 100)
+		t.Assert(gft.AuthPath("/login"), false)
+	})
+	// Kubernetes Secret挂载目录通过替换 ..data 符号链接更新
+	gtest.C(t, func(t *gtest.T) {
+		dir := gfile.Temp(guid.S())
+		defer gfile.Remove(dir)
+		mount := func(version, key string) {
+			data := gfile.Join(dir, "..data_"+version)
+			t.AssertNil(gfile.PutContents(gfile.Join(data, "jwt_key"), key))
+			tmp := gfile.Join(dir, "..data_tmp")
+			t.AssertNil(os.Symlink("..data_"+version, tmp))
+			t.AssertNil(os.Rename(tmp, gfile.Join(dir, "..data")))
+		}
+		mount("1", "0123456789abcdef0123456789abcdef")
+		t.AssertNil(os.Symlink(gfile.Join("..data", "jwt_key"), gfile.Join(dir, "jwt_key")))
+		adapterFile.SetContent(`
+token:
+  watch:
+    signingKey: "file:` + gfile.Join(dir, "jwt_key") + `"
+`)
+		defer adapterFile.ClearContent()
+		gft := gftoken.NewGfToken(gftoken.WithCacheKey("test_watch_config_"), gftoken.WithGCache())
+		t.AssertNil(gft.ReloadConfig(ctx, "token.watch"))
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		t.AssertNil(gft.WatchConfig(watchCtx, "token.watch"))
+		t.Assert(gft.Current().SigningKey, []byte("0123456789abcdef0123456789abcdef"))
+
+		mount("2", "fedcba9876543210fedcba9876543210")
+		for i := 0; i < 50 && string(gft.Current().SigningKey) != "fedcba9876543210fedcba9876543210"; i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Assert(gft.Current().SigningKey, []byte("fedcba9876543210fedcba9876543210"))
+
+		// ctx结束后停止监听
+		cancel()
+		time.Sleep(100 * time.Millisecond)
+		mount("3", "abcdef0123456789abcdef0123456789")
+		time.Sleep(time.Second)
+		t.Assert(gft.Current().SigningKey, []byte("fedcba9876543210fedcba9876543210"))
+	})
+}
+
+func Test_SecretProvider(t *testing.T) {
+	// RFC 5869 A.1
+	gtest.C(t, func(t *gtest.T) {
+		provider := gftoken.HKDFSecretProvider{
+			Master: gftoken.SecretProviderFunc(func(ctx context.Context, name string) ([]byte, error) {
+				return bytes.Repeat([]byte{0x0b}, 22), nil
+			}),
+			Salt: []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c},
+		}
+		key, err := provider.GetSecret(ctx, string([]byte{0xf0, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8, 0xf9}))
+		t.AssertNil(err)
+		t.Assert(hex.EncodeToString(key), "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf")
+	})
+	gtest.C(t, func(t *gtest.T) {
+		t.Setenv("GFTOKEN_TEST_JWT_SIGNING_KEY", "0123456789abcdef0123456789abcdef")
+		t.Setenv("GFTOKEN_TEST_TOKEN_ENCRYPT_KEY", "abcdef0123456789abcdef0123456789")
+		gft, err := gftoken.NewGfTokenE(
+			gftoken.WithCacheKey("test_secret_env_"),
+			gftoken.WithSecretProvider(gftoken.EnvSecretProvider{Prefix: "GFTOKEN_TEST_"}),
+		)
+		t.AssertNil(err)
+		t.Assert(gft.Current().EncryptKey, []byte("abcdef0123456789abcdef0123456789"))
+
+		_, err = gftoken.NewGfTokenE(gftoken.WithSecretProvider(gftoken.EnvSecretProvider{Prefix: "GFTOKEN_MISSING_"}))
+		t.AssertNE(err, nil)
+	})
+	// NewGfToken 获取失败时不panic，也不使用默认密钥，刷新成功前签发及校验均失败
+	gtest.C(t, func(t *gtest.T) {
+		var available = gtype.NewBool()
+		provider := gftoken.SecretProviderFunc(func(ctx context.Context, name string) ([]byte, error) {
+			if !available.Val() {
+				return nil, errors.New("secret provider unavailable")
+			}
+			if name == gftoken.SecretSigningKey {
+				return []byte("0123456789abcdef0123456789abcdef"), nil
+			}
+			return []byte("abcdef0123456789abcdef0123456789"), nil
+		})
+		gft := gftoken.NewGfToken(
+			gftoken.WithCacheKey("test_secret_missing_"),
+			gftoken.WithSecretProvider(provider),
+		)
+		// 使用默认密钥伪造的token
+		forger := gftoken.NewGfToken(gftoken.WithCacheKey("test_secret_missing_"))
+		key := gmd5.MustEncrypt("secret_missing")
+		forged, err := forger.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+
+		_, err = gft.GenerateToken(ctx, key, nil)
+		t.Assert(errors.Is(err, gftoken.ErrSecretUnavailable), true)
+		t.AssertNE(gft.CheckToken(ctx, forged), nil)
+		_, err = gft.VerifyToken(ctx, forged)
+		t.AssertNE(err, nil)
+		t.AssertNE(gft.RefreshSecrets(ctx), nil)
+
+		// 刷新成功后恢复，默认密钥不会加入历史密钥
+		available.Set(true)
+		t.AssertNil(gft.RefreshSecrets(ctx))
+		t.Assert(len(gft.Current().PrevSigningKeys), 0)
+		t.Assert(len(gft.Current().PrevEncryptKeys), 0)
+		token, err := gft.GenerateToken(ctx, key, nil)
+		t.AssertNil(err)
+		t.Assert(gft.IsEffective(ctx, token), true)
+		t.AssertNE(gft.CheckToken(ctx, forged), nil)
+	})
+	// Close 后停止定时刷新密钥
+	gtest.C(t, func(t *gtest.T) {
+		var fetches int32
+		provider := gftoken.SecretProviderFunc(func(ctx context.Context, name string) ([]byte, error) {
+			atomic.AddInt32(&fetches, 1)
+			if name == gftoken.SecretSigningKey {
+				return []byte("0123456789abcdef0123456789abcdef"), nil
+			}
+			return []byte("abcdef0123456789abcdef0123456789"), nil
+		})
+		gft, err := gftoken.NewGfTokenE(
+			gftoken.WithCacheKey("test_secret_close_"),
+			gftoken.WithSecretProvider(provider, 100*time.Millisecond),
+		)
+		t.AssertNil(err)
+		time.Sleep(350 * time.Millisecond)
+		t.AssertGT(atomic.LoadInt32(&fetches), 2)
+		gft.Close()
+		time.Sleep(150 * time.Millisecond)
+		fetched := atomic.LoadInt32(&fetches)
+		time.Sleep(300 * time.Millisecond)
+		t.Assert(atomic.LoadInt32(&fetches), fetched)
+	})
+	gtest.C(t, func(t *gtest.T) {
+		dir := gfile.Temp(guid.S())
+		defer gfile.Remove(dir)
+		t.AssertNil(gfile.PutContents(gfile.Join(dir, gftoken.SecretSigningKey), "0123456789abcdef0123456789abcdef"))
+		t.AssertNil(gfile.PutContents(gfile.Join(dir, gftoken.SecretEncryptKey), "abcdef0123456789abcdef0123456789"))
+		gft, err := gftoken.NewGfTokenE(
+			gftoken.WithCacheKey("test_secret_file_"),
+			gftoken.WithSecretProvider(gftoken.FileSecretProvider{Dir: dir}),
+		)
+		t.AssertNil(err)
+		token, err := gft.GenerateToken(ctx, gmd5.MustEncrypt("secret"), nil)
+		t.AssertNil(err)
+
+		// 更换密钥后刷新，已签发的token继续有效
+		t.AssertNil(gfile.PutContents(gfile.Join(dir, gftoken.SecretSigningKey), "fedcba9876543210fedcba9876543210"))
+		t.AssertNil(gft.RefreshSecrets(ctx))
+		t.Assert(gft.Current().SigningKey, []byte("fedcba9876543210fedcba9876543210"))
+		t.Assert(gft.IsEffective(ctx, token), true)
+	})
+}
+
+type metaAdminReq struct {
+	g.Meta `path:"/admin" method:"get" auth:"required" roles:"admin"`
+}
+
+type metaScopedReq struct {
+	g.Meta `path:"/scoped" method:"get" scopes:"user:read,user:write"`
+}
+
+type metaPublicReq struct {
+	g.Meta `path:"/public" method:"get" auth:"none"`
+}
+
+type metaOptionalReq struct {
+	g.Meta `path:"/optional" method:"get" auth:"optional"`
+}
+
+type metaRes struct {
+	Ok bool `json:"ok"`
+}
+
+type metaController struct{}
+
+func (metaController) Admin(ctx context.Context, req *metaAdminReq) (*metaRes, error) {
+	return &metaRes{Ok: true}, nil
+}
+
+func (metaController) Scoped(ctx context.Context, req *metaScopedReq) (*metaRes, error) {
+	return &metaRes{Ok: true}, nil
+}
+
+func (metaController) Public(ctx context.Context, req *metaPublicReq) (*metaRes, error) {
+	return &metaRes{Ok: true}, nil
+}
+
+func (metaController) Optional(ctx context.Context, req *metaOptionalReq) (*metaRes, error) {
+	return &metaRes{Ok: true}, nil
+}
+
+type metaUser struct {
+	Name   string
+	Roles  []string
+	Scopes string
+}
+
+func (u metaUser) TokenRoles() []string { return u.Roles }
+func (u metaUser) TokenScope() string   { return u.Scopes }
+
+func Test_RouteMeta(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_route_meta_"),
+		gftoken.WithMultiLogin(true),
+		// 排除地址被路由元数据覆盖
+		gftoken.WithExcludePaths(g.SliceStr{"/admin"}),
+		gftoken.WithGCache(),
+	)
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		group.Middleware(ghttp.MiddlewareHandlerResponse)
+		gft.Middleware(group)
+		group.Bind(metaController{})
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(gftoken.RouteMetaOf(&metaAdminReq{}).Roles, g.SliceStr{"admin"})
+		t.AssertNil(gftoken.RouteMetaOf(&struct{ g.Meta }{}))
+
+		prefix := fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort())
+		get := func(path, token string) *gjson.Json {
+			client := g.Client().SetPrefix(prefix)
+			if token != "" {
+				client.SetHeader("Authorization", "Bearer "+token)
+			}
+			return gjson.New(client.GetContent(ctx, path))
+		}
+		key := gmd5.MustEncrypt("route_meta")
+		admin, err := gft.GenerateToken(ctx, key, metaUser{Roles: []string{"admin"}, Scopes: "user:read user:write"})
+		t.AssertNil(err)
+		reader, err := gft.GenerateToken(ctx, key, metaUser{Roles: []string{"user"}, Scopes: "user:read"})
+		t.AssertNil(err)
+
+		t.Assert(get("/public", "").Get("data.ok"), true)
+		t.Assert(get("/optional", "").Get("data.ok"), true)
+		t.Assert(get("/optional", "invalid").Get("data.ok"), true)
+		t.Assert(get("/admin", "").Get("code"), gftoken.FailedAuthCode)
+		t.Assert(get("/admin", reader).Get("code"), gftoken.ForbiddenCode)
+		t.Assert(get("/admin", admin).Get("data.ok"), true)
+		t.Assert(get("/scoped", reader).Get("code"), gftoken.ForbiddenCode)
+		t.Assert(get("/scoped", admin).Get("data.ok"), true)
+	})
+}
+
+func Test_OpenApi(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_openapi_"),
+		gftoken.WithGCache(),
+	)
+	s := g.Server(guid.S())
+	s.SetOpenApiPath("/api.json")
+	gft.BindOpenApi(s, "/api.json")
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		group.Middleware(ghttp.MiddlewareHandlerResponse)
+		gft.Middleware(group)
+		group.Bind(metaController{})
+	})
+	// 未经过认证中间件的路由
+	s.Group("/open", func(group *ghttp.RouterGroup) {
+		group.Middleware(ghttp.MiddlewareHandlerResponse)
+		group.Bind(metaController{})
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		client := g.Client().SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		j := gjson.New(client.GetContent(ctx, "/api.json"))
+		t.Assert(j.Get("components.securitySchemes.BearerAuth.scheme"), "bearer")
+		t.Assert(j.Get("components.securitySchemes.CookieAuth.name"), gftoken.TokenCookieName)
+		t.Assert(j.Get("paths./admin.get.security.0.BearerAuth").Strings(), g.SliceStr{})
+		t.Assert(j.Get("paths./admin.get.x-roles"), "admin")
+		t.Assert(j.Get("paths./scoped.get.security.0.BearerAuth").Strings(), g.SliceStr{})
+		t.Assert(j.Get("paths./scoped.get.security.1.CookieAuth").Strings(), g.SliceStr{})
+		t.Assert(j.Get("paths./scoped.get.x-scopes"), "user:read,user:write")
+		t.Assert(len(j.Get("paths./public.get.security").Array()), 0)
+		t.Assert(j.Get("paths./public.get.security").IsNil(), false)
+		t.Assert(len(j.Get("paths./optional.get.security").Array()), 4)
+		t.Assert(j.Get("paths./open/admin.get").IsNil(), false)
+		t.Assert(j.Get("paths./open/admin.get.security").IsNil(), true)
+		t.Assert(j.Get("paths./open/admin.get.x-roles").IsNil(), true)
+	})
+}
+
+func Test_CodeError(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_code_error_"),
+		gftoken.WithMultiLogin(true),
+		gftoken.WithCodeError(true),
+		gftoken.WithGCache(),
+	)
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		group.Middleware(ghttp.MiddlewareHandlerResponse)
+		gft.Middleware(group)
+		group.Bind(metaController{})
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		prefix := fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort())
+		get := func(path, token string) *gjson.Json {
+			client := g.Client().SetPrefix(prefix)
+			if token != "" {
+				client.SetHeader("Authorization", "Bearer "+token)
+			}
+			return gjson.New(client.GetContent(ctx, path))
+		}
+		key := gmd5.MustEncrypt("code_error")
+		admin, err := gft.GenerateToken(ctx, key, metaUser{Roles: []string{"admin"}})
+		t.AssertNil(err)
+		reader, err := gft.GenerateToken(ctx, key, metaUser{Roles: []string{"user"}})
+		t.AssertNil(err)
+
+		j := get("/admin", "")
+		t.Assert(j.Get("code"), 40100)
+		t.Assert(j.Get("message"), gftoken.ErrorsAuthFailed)
+		t.Assert(get("/admin", reader).Get("code"), gftoken.CodeForbidden.Code())
+		t.Assert(get("/admin", admin).Get("data.ok"), true)
+
+		t.AssertNil(gft.RemoveToken(ctx, admin))
+		t.Assert(get("/admin", admin).Get("code"), gftoken.CodeTokenRevoked.Code())
+		t.Assert(gftoken.AuthErrorCode(gftoken.ErrTokenExpired), gftoken.CodeTokenExpired)
+	})
+}
+
+func Test_HttpMiddleware(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_http_middleware_"),
+		gftoken.WithServerName("http"),
+		gftoken.WithExcludePaths(g.SliceStr{"/public", "/login"}),
+		gftoken.WithDeviceBinding(true),
+		gftoken.WithGCache(),
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		identity := gftoken.IdentityFromContext(r.Context())
+		if identity == nil {
+			_, _ = w.Write([]byte("anonymous"))
+			return
+		}
+		_, _ = w.Write([]byte(identity.Server + ":" + identity.UserKey))
+	})
+	ts := httptest.NewServer(gft.HttpMiddleware(mux))
+	defer ts.Close()
+
+	gtest.C(t, func(t *gtest.T) {
+		do := func(path, token, deviceId string) (int, string) {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			req.Header.Set(gftoken.DeviceIdHeader, deviceId)
+			res, err := http.DefaultClient.Do(req)
+			t.AssertNil(err)
+			defer res.Body.Close()
+			buf := new(bytes.Buffer)
+			_, _ = buf.ReadFrom(res.Body)
+			return res.StatusCode, buf.String()
+		}
+		// 登录时请求中的设备信息同样用于设备绑定
+		loginReq := httptest.NewRequest(http.MethodPost, "/login", nil)
+		loginReq.Header.Set(gftoken.DeviceIdHeader, "device-a")
+		loginReq.Header.Set("User-Agent", "Go-http-client/1.1")
+		key := gmd5.MustEncrypt("http_middleware")
+		var token string
+		gft.HttpMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var err error
+			token, err = gft.GenerateToken(r.Context(), key, "data")
+			t.AssertNil(err)
+		})).ServeHTTP(httptest.NewRecorder(), loginReq)
+		t.AssertNE(token, "")
+
+		code, body := do("/public", "", "")
+		t.Assert(code, http.StatusOK)
+		t.Assert(body, "anonymous")
+		code, body = do("/user", "", "")
+		t.Assert(code, http.StatusUnauthorized)
+		t.Assert(gjson.New(body).Get("code"), gftoken.FailedAuthCode)
+		code, body = do("/user", token, "device-a")
+		t.Assert(code, http.StatusOK)
+		t.Assert(body, "http:"+key)
+		code, _ = do("/user", token, "device-b")
+		t.Assert(code, http.StatusUnauthorized)
+
+		req := httptest.NewRequest(http.MethodGet, "/user?token="+gurl.Encode(token), nil)
+		req.Header.Set(gftoken.DeviceIdHeader, "device-a")
+		req.Header.Set("User-Agent", "Go-http-client/1.1")
+		identity, err := gft.VerifyRequest(req)
+		t.AssertNil(err)
+		t.Assert(identity.UserKey, key)
+		claims, err := gft.ParseHttpToken(req)
+		t.AssertNil(err)
+		t.Assert(claims.Data, "data")
+	})
+}
+
+func Test_WebSocket(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_websocket_"),
+		gftoken.WithMultiLogin(true),
+		gftoken.WithGCache(),
+	)
+	short := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_websocket_short_"),
+		gftoken.WithTimeoutAndMaxRefresh(2, 2),
+		gftoken.WithLeeway(0),
+		gftoken.WithGCache(),
+	)
+	legacy := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_websocket_legacy_"),
+		gftoken.WithDistConfig(&adapter.Config{Dir: gfile.Temp(guid.S())}),
+	)
+	echo := func(gft *gftoken.GfToken) ghttp.HandlerFunc {
+		return func(r *ghttp.Request) {
+			ws, err := gft.WebSocket(r, gftoken.WebSocketOptions{Revalidate: 200 * time.Millisecond})
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+			for {
+				msgType, msg, err := ws.ReadMessage()
+				if err != nil {
+					return
+				}
+				if err = ws.WriteMessage(msgType, []byte(ws.Identity.UserKey+":"+string(msg))); err != nil {
+					return
+				}
+			}
+		}
+	}
+	s := g.Server(guid.S())
+	s.BindHandler("/ws", echo(gft))
+	s.BindHandler("/short", echo(short))
+	s.BindHandler("/legacy", echo(legacy))
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		url := fmt.Sprintf("ws://127.0.0.1:%d", s.GetListenedPort())
+		key := gmd5.MustEncrypt("websocket")
+		token, err := gft.GenerateToken(ctx, key, "data")
+		t.AssertNil(err)
+		send := func(conn *websocket.Conn, msg string) string {
+			t.AssertNil(conn.WriteMessage(websocket.TextMessage, []byte(msg)))
+			_, res, err := conn.ReadMessage()
+			t.AssertNil(err)
+			return string(res)
+		}
+		closeCode := func(conn *websocket.Conn) int {
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err := conn.ReadMessage()
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return closeErr.Code
+			}
+			return 0
+		}
+
+		// 握手时token无效
+		_, res, err := websocket.DefaultDialer.Dial(url+"/ws?token=invalid", nil)
+		t.AssertNE(err, nil)
+		t.Assert(res.StatusCode, http.StatusUnauthorized)
+
+		// 跨站请求
+		_, res, err = websocket.DefaultDialer.Dial(url+"/ws?token="+gurl.Encode(token), http.Header{"Origin": {"https://evil.example.com"}})
+		t.AssertNE(err, nil)
+		t.Assert(res.StatusCode, http.StatusForbidden)
+		sameOrigin, _, err := websocket.DefaultDialer.Dial(url+"/ws?token="+gurl.Encode(token), http.Header{
+			"Origin": {strings.Replace(url, "ws://", "http://", 1)},
+		})
+		t.AssertNil(err)
+		t.Assert(send(sameOrigin, "hi"), key+":hi")
+		sameOrigin.Close()
+
+		// 查询参数
+		byQuery, _, err := websocket.DefaultDialer.Dial(url+"/ws?token="+gurl.Encode(token), nil)
+		t.AssertNil(err)
+		defer byQuery.Close()
+		t.Assert(send(byQuery, "hi"), key+":hi")
+
+		// 子协议
+		dialer := websocket.Dialer{Subprotocols: gftoken.WebSocketProtocols(token)}
+		byProtocol, res, err := dialer.Dial(url+"/ws", nil)
+		t.AssertNil(err)
+		defer byProtocol.Close()
+		t.Assert(res.Header.Get("Sec-WebSocket-Protocol"), gftoken.WebSocketProtocol)
+		t.Assert(send(byProtocol, "hi"), key+":hi")
+
+		// 首条消息
+		byMessage, _, err := websocket.DefaultDialer.Dial(url+"/ws", nil)
+		t.AssertNil(err)
+		defer byMessage.Close()
+		t.AssertNil(byMessage.WriteMessage(websocket.TextMessage, []byte(`{"token":"`+token+`"}`)))
+		t.Assert(send(byMessage, "hi"), key+":hi")
+
+		invalid, _, err := websocket.DefaultDialer.Dial(url+"/ws", nil)
+		t.AssertNil(err)
+		defer invalid.Close()
+		t.AssertNil(invalid.WriteMessage(websocket.TextMessage, []byte("invalid")))
+		t.Assert(closeCode(invalid), websocket.ClosePolicyViolation)
+
+		// 退出登录时立即关闭会话的全部连接
+		t.AssertNil(gft.RemoveToken(ctx, token))
+		t.Assert(closeCode(byQuery), websocket.ClosePolicyViolation)
+		t.Assert(closeCode(byProtocol), websocket.ClosePolicyViolation)
+		t.Assert(closeCode(byMessage), websocket.ClosePolicyViolation)
+
+		// 定时校验不刷新会话，空闲连接在会话过期后关闭
+		token, err = short.GenerateToken(ctx, key, "data")
+		t.AssertNil(err)
+		expiring, _, err := websocket.DefaultDialer.Dial(url+"/short?token="+gurl.Encode(token), nil)
+		t.AssertNil(err)
+		defer expiring.Close()
+		t.Assert(send(expiring, "hi"), key+":hi")
+		t.Assert(closeCode(expiring), websocket.ClosePolicyViolation)
+	})
+
+	// 未携带jti的旧token，退出时不影响其他用户的连接
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			url  = fmt.Sprintf("ws://127.0.0.1:%d/legacy?token=", s.GetListenedPort())
+			dist = adapter.NewDist()
+		)
+		legacyToken := func(userKey string) string {
+			token, err := legacy.GenerateToken(ctx, userKey, nil)
+			t.AssertNil(err)
+			v, err := dist.Get(ctx, "test_websocket_legacy_"+userKey)
+			t.AssertNil(err)
+			var tData *gftoken.TokenData
+			t.AssertNil(v.Scan(&tData))
+			signer := &gftoken.JwtSign{SigningKey: legacy.Current().SigningKey}
+			claims, err := signer.ParseToken(tData.JwtToken)
+			t.AssertNil(err)
+			claims.ID = ""
+			tData.JwtToken, err = signer.CreateToken(*claims)
+			t.AssertNil(err)
+			t.AssertNil(dist.Set(ctx, "test_websocket_legacy_"+userKey, gjson.MustEncodeString(tData), time.Hour))
+			return token
+		}
+		first := legacyToken(gmd5.MustEncrypt("legacy_first"))
+		second := legacyToken(gmd5.MustEncrypt("legacy_second"))
+		conn, _, err := websocket.DefaultDialer.Dial(url+gurl.Encode(second), nil)
+		t.AssertNil(err)
+		defer conn.Close()
+		t.AssertNil(legacy.RemoveToken(ctx, first))
+		t.AssertNil(conn.WriteMessage(websocket.TextMessage, []byte("hi")))
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := conn.ReadMessage()
+		t.AssertNil(err)
+		t.Assert(string(msg), gmd5.MustEncrypt("legacy_second")+":hi")
+	})
+}
+
+func Test_MultiRealm(t *testing.T) {
+	admin := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_realm_admin_"),
+		gftoken.WithRealm("admin"),
+		gftoken.WithEncryptKey([]byte("0123456789abcdef0123456789abcdef")),
+		gftoken.WithGCache(),
+	)
+	app := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_realm_app_"),
+		gftoken.WithRealm("app"),
+		gftoken.WithGCache(),
+	)
+	legacy := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_realm_legacy_"),
+		gftoken.WithGCache(),
+	)
+	realms := gftoken.NewMultiRealm(admin, app, legacy)
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		realms.Middleware(group)
+		group.GET("/shared", func(r *ghttp.Request) {
+			identity := gftoken.IdentityFromContext(r.GetCtx())
+			r.Response.Write(identity.Realm + ":" + identity.Server)
+		})
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		client := g.Client().SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		get := func(token string) string {
+			return client.Header(g.MapStrStr{"Authorization": "Bearer " + token}).GetContent(ctx, "/shared")
+		}
+		key := gmd5.MustEncrypt("realm")
+		adminToken, err := admin.GenerateToken(ctx, key, "admin")
+		t.AssertNil(err)
+		appToken, err := app.GenerateToken(ctx, key, "app")
+		t.AssertNil(err)
+		legacyToken, err := legacy.GenerateToken(ctx, key, "legacy")
+		t.AssertNil(err)
+		t.Assert(gftoken.TokenRealm(adminToken), "admin")
+		t.Assert(gftoken.TokenRealm(legacyToken), "")
+
+		t.Assert(get(adminToken), "admin:defaultGFToken")
+		t.Assert(get(appToken), "app:defaultGFToken")
+		t.Assert(get(legacyToken), ":defaultGFToken")
+		t.Assert(gjson.New(get("other."+legacyToken)).Get("code"), gftoken.FailedAuthCode)
+
+		// 其他领域的token不会被解密
+		t.Assert(errors.Is(admin.CheckToken(ctx, appToken), gftoken.ErrTokenInvalid), true)
+		identity, err := realms.VerifyToken(ctx, appToken)
+		t.AssertNil(err)
+		t.Assert(identity.Realm, "app")
+		t.Assert(identity.Claims.Realm, "app")
+		t.Assert(realms.Realm(adminToken), admin)
+		t.Assert(errors.Is(admin.CheckToken(ctx, appToken), gftoken.ErrRealmMismatch), true)
+	})
+
+	// 去掉领域前缀后仍会按claims中的领域校验
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			encryptKey = gftoken.WithEncryptKey([]byte("fedcba9876543210fedcba9876543210"))
+			cacheKey   = gftoken.WithCacheKey("test_realm_claims_")
+		)
+		issuer := gftoken.NewGfToken(cacheKey, encryptKey, gftoken.WithRealm("x"))
+		other := gftoken.NewGfToken(cacheKey, encryptKey)
+		token, err := issuer.GenerateToken(ctx, gmd5.MustEncrypt("realm_claims"), "x")
+		t.AssertNil(err)
+		t.Assert(gftoken.TokenRealm(token), "x")
+		t.AssertNil(issuer.CheckToken(ctx, token))
+		err = other.CheckToken(ctx, strings.TrimPrefix(token, "x."))
+		t.Assert(errors.Is(err, gftoken.ErrRealmMismatch), true)
+		t.Assert(gftoken.FailureReason(err), "realm_mismatch")
+	})
+
+	gtest.C(t, func(t *gtest.T) {
+		defer func() {
+			t.AssertNE(recover(), nil)
+		}()
+		gftoken.NewMultiRealm()
+	})
+}
+
+func Test_Tenant(t *testing.T) {
+	var (
+		acmeKey    = []byte("acme0123456789abcdef0123456789ab")
+		maxRefresh = int64(50)
+	)
+	provider := gftoken.TenantConfigProviderFunc(func(ctx context.Context, tenant string) (*gftoken.TenantConfig, error) {
+		switch tenant {
+		case "acme":
+			return &gftoken.TenantConfig{
+				SigningKey: []byte("acme-signing-key-0123456789abcdef"),
+				EncryptKey: acmeKey,
+				Timeout:    100,
+				MaxRefresh: &maxRefresh,
+			}, nil
+		case "banned":
+			return nil, errors.New("tenant disabled")
+		}
+		return nil, nil
+	})
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_tenant_"),
+		gftoken.WithTenants(provider),
+		gftoken.WithTenantResolver(gftoken.HeaderTenant("X-Tenant")),
+		gftoken.WithGCache(),
+	)
+	s := g.Server(guid.S())
+	s.Group("/", func(group *ghttp.RouterGroup) {
+		gft.Middleware(group)
+		group.GET("/me", func(r *ghttp.Request) {
+			r.Response.Write(gftoken.IdentityFromContext(r.GetCtx()).Tenant)
+		})
+	})
+	s.SetDumpRouterMap(false)
+	s.Start()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			key       = gmd5.MustEncrypt("tenant")
+			acmeCtx   = gftoken.ContextWithTenant(ctx, "acme")
+			globexCtx = gftoken.ContextWithTenant(ctx, "globex")
+		)
+		acme, err := gft.GenerateToken(acmeCtx, key, "acme")
+		t.AssertNil(err)
+		t.Assert(gftoken.TokenTenant(acme), "acme")
+		// 不允许多点登录时其他租户的同一用户不会顶替会话
+		globex, err := gft.GenerateToken(globexCtx, key, "globex")
+		t.AssertNil(err)
+		root, err := gft.GenerateToken(ctx, key, "root")
+		t.AssertNil(err)
+		t.Assert(gftoken.TokenTenant(root), "")
+
+		identity, err := gft.VerifyToken(ctx, acme)
+		t.AssertNil(err)
+		t.Assert(identity.Tenant, "acme")
+		t.Assert(identity.Claims.Tenant, "acme")
+		t.Assert(identity.Claims.ExpiresAt.Unix()-identity.Claims.IssuedAt.Unix(), 150)
+		identity, err = gft.VerifyToken(globexCtx, globex)
+		t.AssertNil(err)
+		t.Assert(identity.Tenant, "globex")
+		t.AssertNil(gft.CheckToken(ctx, root))
+		t.Assert(gft.CheckToken(globexCtx, acme), gftoken.ErrTenantMismatch)
+		t.Assert(gft.CheckToken(gftoken.ContextWithTenant(ctx, ""), acme), gftoken.ErrTenantMismatch)
+		// 篡改租户标识无法通过校验
+		t.AssertNE(gft.CheckToken(ctx, strings.Replace(acme, "acme~", "globex~", 1)), nil)
+
+		client := g.Client().SetPrefix(fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort()))
+		get := func(tenant, token string) string {
+			return client.Header(g.MapStrStr{
+				"X-Tenant":      tenant,
+				"Authorization": "Bearer " + token,
+			}).GetContent(ctx, "/me")
+		}
+		t.Assert(get("acme", acme), "acme")
+		t.Assert(get("globex", globex), "globex")
+		t.Assert(get("", root), "")
+		t.Assert(gjson.New(get("globex", acme)).Get("code"), gftoken.FailedAuthCode)
+		t.Assert(gjson.New(get("", acme)).Get("code"), gftoken.FailedAuthCode)
+		t.Assert(gjson.New(get("acme", root)).Get("code"), gftoken.FailedAuthCode)
+
+		// 刷新令牌同样属于租户
+		res, err := gft.Login(acmeCtx, key, "acme")
+		t.AssertNil(err)
+		t.Assert(gftoken.TokenTenant(res.RefreshToken), "acme")
+		_, err = gft.ExchangeRefreshToken(globexCtx, res.RefreshToken)
+		t.Assert(err, gftoken.ErrTenantMismatch)
+		res, err = gft.ExchangeRefreshToken(ctx, res.RefreshToken)
+		t.AssertNil(err)
+		t.Assert(gftoken.TokenTenant(res.Token), "acme")
+
+		_, err = gft.GenerateToken(gftoken.ContextWithTenant(ctx, "banned"), key, nil)
+		t.AssertNE(err, nil)
+		_, err = gft.GenerateToken(gftoken.ContextWithTenant(ctx, "a:b"), key, nil)
+		t.AssertNE(err, nil)
+
+		// 更换租户密钥后已签发的token仍然有效
+		acmeKey = []byte("acme-rotated-0123456789abcdef012")
+		t.AssertNil(gft.ReloadTenant(ctx, "acme"))
+		t.AssertNil(gft.CheckToken(ctx, res.Token))
+	})
+	// 实例配置热更新后，租户未设置的项使用新的配置及密钥
+	gtest.C(t, func(t *gtest.T) {
+		var calls int32
+		gft, err := gftoken.NewGfTokenE(
+			gftoken.WithCacheKey("test_tenant_reload_"),
+			gftoken.WithTimeoutAndMaxRefresh(10, 5),
+			gftoken.WithUserJwt("0123456789abcdef0123456789abcdef"),
+			gftoken.WithEncryptKey([]byte("abcdef0123456789abcdef0123456789")),
+			gftoken.WithTenants(gftoken.TenantConfigProviderFunc(func(ctx context.Context, tenant string) (*gftoken.TenantConfig, error) {
+				atomic.AddInt32(&calls, 1)
+				if tenant == "partial" {
+					return &gftoken.TenantConfig{Timeout: 100}, nil
+				}
+				return nil, nil
+			})),
+			gftoken.WithGCache(),
+		)
+		t.AssertNil(err)
+		var (
+			key        = gmd5.MustEncrypt("tenant_reload")
+			partialCtx = gftoken.ContextWithTenant(ctx, "partial")
+			globexCtx  = gftoken.ContextWithTenant(ctx, "globex")
+			lifetime   = func(token string) int64 {
+				identity, err := gft.VerifyToken(ctx, token)
+				t.AssertNil(err)
+				return identity.Claims.ExpiresAt.Unix() - identity.Claims.IssuedAt.Unix()
+			}
+		)
+		partial, err := gft.GenerateToken(partialCtx, key, nil)
+		t.AssertNil(err)
+		globex, err := gft.GenerateToken(globexCtx, key, nil)
+		t.AssertNil(err)
+		t.Assert(lifetime(partial), 105)
+		t.Assert(lifetime(globex), 15)
+		// 有独立配置的租户只获取一次配置
+		t.Assert(atomic.LoadInt32(&calls), 3)
+
+		conf := *gft.Current()
+		conf.MaxRefresh = 7
+		conf.SigningKey = []byte("fedcba9876543210fedcba9876543210")
+		conf.EncryptKey = []byte("9876543210abcdef9876543210abcdef")
+		t.AssertNil(gft.Reload(conf))
+		// 已签发的token使用历史密钥校验
+		t.AssertNil(gft.CheckToken(ctx, partial))
+		t.AssertNil(gft.CheckToken(ctx, globex))
+
+		partial, err = gft.GenerateToken(partialCtx, key, nil)
+		t.AssertNil(err)
+		globex, err = gft.GenerateToken(globexCtx, key, nil)
+		t.AssertNil(err)
+		t.Assert(lifetime(partial), 107)
+		t.Assert(lifetime(globex), 17)
+	})
+}
@@ -0,0 +1,164 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/grpool"
+	"io"
+	"time"
+)
+
+// 关闭时等待异步回调执行完成的最长时间
+const hookFlushTimeout = 5 * time.Second
+
+// EventType token生命周期事件类型
+type EventType string
+
+const (
+	EventLogin      EventType = "login"      // 签发token
+	EventRefresh    EventType = "refresh"    // 自动刷新token
+	EventLogout     EventType = "logout"     // 删除token
+	EventRevoke     EventType = "revoke"     // 通过撤销接口删除token，回调 OnLogout
+	EventAuthFailed EventType = "authFailed" // 请求认证或登录失败
+)
+
+// Event token生命周期事件
+type Event struct {
+	Type      EventType
+	Server    string        // 服务名称，即GfToken的ServerName
+	UserKey   string        // 用户标识，即jwt的sub
+	Account   string        // 登录提交的用户名，仅登录失败事件设置
+	SessionId string        // 会话ID，即jwt的jti
+	Claims    *CustomClaims // token携带的数据，认证失败时可能为nil
+	Reason    error         // 认证失败原因
+	Time      time.Time
+	// 触发事件的请求信息，在请求协程中读取，异步回调中请使用这些字段而不是上下文中的请求
+	ClientIp  string
+	UserAgent string
+	Path      string
+}
+
+// Hook token生命周期回调
+type Hook interface {
+	OnLogin(ctx context.Context, e *Event)
+	OnRefresh(ctx context.Context, e *Event)
+	OnLogout(ctx context.Context, e *Event)
+	OnAuthFailed(ctx context.Context, e *Event)
+}
+
+// HookFuncs 以函数形式实现 Hook，未设置的回调将被忽略
+type HookFuncs struct {
+	Login      func(ctx context.Context, e *Event)
+	Refresh    func(ctx context.Context, e *Event)
+	Logout     func(ctx context.Context, e *Event)
+	AuthFailed func(ctx context.Context, e *Event)
+}
+
+func (h HookFuncs) OnLogin(ctx context.Context, e *Event) {
+	if h.Login != nil {
+		h.Login(ctx, e)
+	}
+}
+
+func (h HookFuncs) OnRefresh(ctx context.Context, e *Event) {
+	if h.Refresh != nil {
+		h.Refresh(ctx, e)
+	}
+}
+
+func (h HookFuncs) OnLogout(ctx context.Context, e *Event) {
+	if h.Logout != nil {
+		h.Logout(ctx, e)
+	}
+}
+
+func (h HookFuncs) OnAuthFailed(ctx context.Context, e *Event) {
+	if h.AuthFailed != nil {
+		h.AuthFailed(ctx, e)
+	}
+}
+
+// 已注册的回调，pool不为nil时异步执行
+type hookEntry struct {
+	hook Hook
+	pool *grpool.Pool
+}
+
+func newEvent(eventType EventType, claims *CustomClaims, reason error) *Event {
+	e := &Event{
+		Type:   eventType,
+		Claims: claims,
+		Reason: reason,
+		Time:   time.Now(),
+	}
+	if claims != nil {
+		e.UserKey = claims.Subject
+		e.SessionId = claims.ID
+	}
+	return e
+}
+
+// 触发生命周期事件
+func (m *GfToken) emit(ctx context.Context, e *Event) {
+	e.Server = m.ServerName
+	if r := requestFromCtx(ctx); r != nil {
+		e.ClientIp = m.clientIp(ctx)
+		e.UserAgent = r.UserAgent()
+		e.Path = r.URL.Path
+	}
+	if e.Type == EventLogout || e.Type == EventRevoke {
+		// 立即关闭会话的WebSocket连接
+		webSocketSessions.end(e.SessionId, ErrTokenRevoked)
+	}
+	for _, entry := range m.hooks {
+		if entry.pool == nil {
+			dispatch(ctx, entry.hook, e)
+			continue
+		}
+		hook := entry.hook
+		// 请求结束后上下文会被取消，异步回调使用不会结束的上下文
+		err := entry.pool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			dispatch(ctx, hook, e)
+		}, func(ctx context.Context, exception error) {
+			g.Log().Error(ctx, "[GFToken]hook panic:", exception)
+		})
+		if err != nil {
+			g.Log().Error(ctx, "[GFToken]add hook job:", err)
+		}
+	}
+}
+
+func dispatch(ctx context.Context, hook Hook, e *Event) {
+	switch e.Type {
+	case EventLogin:
+		hook.OnLogin(ctx, e)
+	case EventRefresh:
+		hook.OnRefresh(ctx, e)
+	case EventLogout, EventRevoke:
+		hook.OnLogout(ctx, e)
+	case EventAuthFailed:
+		hook.OnAuthFailed(ctx, e)
+	}
+}
+
+// 等待异步回调执行完成后关闭协程池，并关闭实现了 io.Closer 的回调
+func (m *GfToken) closeHooks() {
+	deadline := time.Now().Add(hookFlushTimeout)
+	for _, entry := range m.hooks {
+		if entry.pool == nil {
+			continue
+		}
+		for (entry.pool.Jobs() > 0 || entry.pool.Size() > 0) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		entry.pool.Close()
+	}
+	for _, entry := range m.hooks {
+		if closer, ok := entry.hook.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				g.Log().Error(gctx.New(), "[GFToken]close hook:", err)
+			}
+		}
+	}
+}
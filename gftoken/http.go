@@ -0,0 +1,230 @@
+package gftoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Identity 认证通过的用户身份
+type Identity struct {
+	Server    string        // 认证的实例名称
+	Realm     string        // 认证的实例领域标识
+	Tenant    string        // 所属租户
+	UserKey   string        // 用户标识
+	SessionId string        // 会话ID，即jwt的jti
+	Scope     string        // 授权范围 多个以空格分隔
+	Roles     []string      // 角色
+	Claims    *CustomClaims // token携带的全部信息
+	Token     string        // 请求携带的token，调用下游服务时可转发
+}
+
+type identityCtxKey struct{}
+
+type httpRequestCtxKey struct{}
+
+// ContextWithIdentity 在上下文中设置用户身份
+func ContextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityCtxKey{}, identity)
+}
+
+// IdentityFromContext 获取中间件认证通过后设置的用户身份，未登录时返回nil
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityCtxKey{}).(*Identity)
+	return identity
+}
+
+func (m *GfToken) newIdentity(claims *CustomClaims, token string) *Identity {
+	return &Identity{
+		Token:     token,
+		Server:    m.ServerName,
+		Realm:     m.realm,
+		Tenant:    claims.Tenant,
+		UserKey:   claims.Subject,
+		SessionId: claims.ID,
+		Scope:     claims.Scope,
+		Roles:     claims.Roles,
+		Claims:    claims,
+	}
+}
+
+// RequestToken 获取net/http请求携带的token，依次从Authorization请求头、查询参数token、cookie中获取
+func RequestToken(r *http.Request) string {
+	if token := bearerToken(r.Header.Get("Authorization")); token != "" {
+		return token
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(TokenCookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func bearerToken(auth string) string {
+	n := len(BearerPrefix)
+	if len(auth) >= n && auth[:n] == BearerPrefix {
+		return auth[n:]
+	}
+	return ""
+}
+
+// VerifyToken 与 IsEffective 相同校验token并自动刷新，返回用户身份
+// 会话被新登录顶替时返回 *SessionReplacedError
+func (m *GfToken) VerifyToken(ctx context.Context, token string) (*Identity, error) {
+	claims, err := m.checkToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return m.newIdentity(claims, token), nil
+}
+
+// VerifyRequest 校验net/http请求携带的token并自动刷新，返回用户身份
+func (m *GfToken) VerifyRequest(r *http.Request) (*Identity, error) {
+	return m.VerifyToken(contextWithHttpRequest(r.Context(), r), RequestToken(r))
+}
+
+// ParseHttpToken 解析net/http请求携带的token (只验证格式并不验证过期)
+func (m *GfToken) ParseHttpToken(r *http.Request) (*CustomClaims, error) {
+	return m.parseToken(r.Context(), RequestToken(r))
+}
+
+// HttpMiddleware net/http中间件，排除路径外的请求必须携带有效token，
+// 认证通过后可通过 IdentityFromContext 获取用户身份，排除路径中签发的token同样记录请求的设备信息；
+// 认证失败时响应401(无权限时为403)状态码及与 Middleware 相同的json内容
+func (m *GfToken) HttpMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 后续处理中签发token时同样可获取设备信息及租户
+		parent := m.withRequestTenant(contextWithHttpRequest(r.Context(), r), r)
+		ctx, span := m.startSpan(parent, spanAuthMiddleware)
+		token := RequestToken(r)
+		claims, res, err := m.authenticate(ctx, r.URL.Path, token, nil)
+		endSpan(span, &err)
+		if res != nil {
+			writeHttpFailed(w, m.ServerName, res)
+			return
+		}
+		if claims != nil {
+			parent = ContextWithIdentity(parent, m.newIdentity(claims, token))
+		}
+		next.ServeHTTP(w, r.WithContext(parent))
+	})
+}
+
+func writeHttpFailed(w http.ResponseWriter, realm string, res *AuthFailed) {
+	status := http.StatusUnauthorized
+	if res.Code == ForbiddenCode {
+		status = http.StatusForbidden
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// 校验token是否满足路由的认证要求，未通过时返回响应内容及失败原因
+// 不需要认证或可选认证未登录时claims为nil
+func (m *GfToken) authenticate(ctx context.Context, urlPath, token string, meta *RouteMeta) (
+	claims *CustomClaims, failed *AuthFailed, err error) {
+	auth := m.authMode(urlPath, meta)
+	if auth == AuthNone {
+		// 如果不需要认证，继续
+		return
+	}
+	if auth == AuthOptional && token == "" {
+		return
+	}
+	claims, err = m.checkToken(ctx, token)
+	if err != nil && auth == AuthOptional {
+		// 可选认证时token无效按未登录处理
+		g.Log().Debug(ctx, err)
+		return nil, nil, nil
+	}
+	if err == nil {
+		err = meta.authorize(claims)
+	}
+	if errors.Is(err, ErrForbidden) {
+		m.emit(ctx, newEvent(EventAuthFailed, claims, err))
+		failed = &AuthFailed{
+			Code:    ForbiddenCode,
+			Message: ErrorsForbidden,
+		}
+		return nil, failed, err
+	}
+	if err != nil {
+		g.Log().Info(ctx, err)
+		m.emit(ctx, newEvent(EventAuthFailed, nil, err))
+		failed = &AuthFailed{
+			Code:    FailedAuthCode,
+			Message: ErrorsAuthFailed,
+		}
+		// 被其他设备登录顶替时返回顶替时间及设备
+		var replacedErr *SessionReplacedError
+		if errors.As(err, &replacedErr) {
+			failed.Message = ErrorsSessionReplaced
+			failed.Data = replacedErr.Replaced.public()
+		}
+	}
+	return
+}
+
+func contextWithHttpRequest(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, httpRequestCtxKey{}, r)
+}
+
+// 上下文中的原始请求，依次从ghttp请求及net/http中间件设置的请求中获取
+func requestFromCtx(ctx context.Context) *http.Request {
+	if r := ghttp.RequestFromCtx(ctx); r != nil {
+		return r.Request
+	}
+	r, _ := ctx.Value(httpRequestCtxKey{}).(*http.Request)
+	return r
+}
+
+// 上下文中请求的客户端IP，默认为直连地址；直连地址属于可信代理时，
+// 从右向左取X-Forwarded-For中第一个不属于可信代理的地址，没有时使用X-Real-IP
+func (m *GfToken) clientIp(ctx context.Context) string {
+	r := requestFromCtx(ctx)
+	if r == nil {
+		return ""
+	}
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !m.trustedProxy(remote) {
+		return remote
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip != "" && !m.trustedProxy(ip) {
+			return ip
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	return remote
+}
+
+// 地址是否属于可信代理
+func (m *GfToken) trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, network := range m.trustedProxies {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
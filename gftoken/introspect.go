@@ -0,0 +1,72 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// IntrospectResponse token内省结果 (RFC 7662)
+type IntrospectResponse struct {
+	Active    bool        `json:"active"`
+	Scope     string      `json:"scope,omitempty"`
+	TokenType string      `json:"token_type,omitempty"`
+	Exp       int64       `json:"exp,omitempty"`
+	Iat       int64       `json:"iat,omitempty"`
+	Nbf       int64       `json:"nbf,omitempty"`
+	Sub       string      `json:"sub,omitempty"`
+	Aud       []string    `json:"aud,omitempty"`
+	Iss       string      `json:"iss,omitempty"`
+	Jti       string      `json:"jti,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// IntrospectHandler token内省接口 (RFC 7662)
+// 调用方需通过 WithClientCredentials 配置的客户端凭证认证(HTTP Basic 或 client_id/client_secret 参数)，
+// 待检查的token通过表单参数token提交。
+// 该接口不能挂载在 Middleware 之后，否则请求会先被当作普通token认证拦截。
+func (m *GfToken) IntrospectHandler(r *ghttp.Request) {
+	r.Response.Header().Set("Cache-Control", "no-store")
+	if !m.authenticateClient(r) {
+		m.writeInvalidClient(r)
+		return
+	}
+	r.Response.WriteJson(m.Introspect(r.GetCtx(), r.Get("token").String()))
+}
+
+// Introspect 检查token并返回内省结果，token无效、已过期或已注销时只返回active=false
+func (m *GfToken) Introspect(ctx context.Context, token string) *IntrospectResponse {
+	if v, err := m.scoped(ctx, token); err != nil || v != m {
+		if err != nil {
+			return &IntrospectResponse{}
+		}
+		return v.Introspect(ctx, token)
+	}
+	tData, _, err := m.GetTokenData(ctx, token)
+	if err != nil {
+		return &IntrospectResponse{}
+	}
+	claims, code := m.IsNotExpired(tData.JwtToken)
+	if code != JwtTokenOK {
+		return &IntrospectResponse{}
+	}
+	res := &IntrospectResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		TokenType: "Bearer",
+		Sub:       claims.Subject,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+		Data:      claims.Data,
+	}
+	if claims.ExpiresAt != nil {
+		res.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		res.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		res.Nbf = claims.NotBefore.Unix()
+	}
+	return res
+}
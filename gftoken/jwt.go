@@ -9,13 +9,21 @@ import (
 // 使用工厂创建一个 JWT 结构体
 func CreateMyJWT(JwtTokenSignKey string) *JwtSign {
 	return &JwtSign{
-		[]byte(JwtTokenSignKey),
+		SigningKey: []byte(JwtTokenSignKey),
 	}
 }
 
 // 定义一个 JWT验签 结构体
 type JwtSign struct {
 	SigningKey []byte
+	// 轮换前的签名key 仅用于校验已签发的token
+	VerifyKeys [][]byte
+	// 签发者 不为空时解析token将校验iss
+	Issuer string
+	// 受众 不为空时解析token将校验aud(包含其中任意一个即可)
+	Audience []string
+	// 校验nbf、exp、iat时允许的时钟偏差
+	Leeway time.Duration
 }
 
 // CreateToken 生成一个token
@@ -28,22 +36,33 @@ func (j *JwtSign) CreateToken(claims CustomClaims) (string, error) {
 
 // 解析Token (只验证格式并不验证过期)
 func (j *JwtSign) ParseToken(tokenString string) (*CustomClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return j.SigningKey, nil
-	})
-	if err!=nil{
-		return nil,err
+	token, err := j.parse(tokenString, j.SigningKey)
+	// 签名不匹配时使用轮换前的key校验
+	for i := 0; i < len(j.VerifyKeys) && errors.Is(err, jwt.ErrTokenSignatureInvalid); i++ {
+		token, err = j.parse(tokenString, j.VerifyKeys[i])
+	}
+	if err != nil {
+		return nil, err
 	}
 	if token == nil {
 		return nil, errors.New(ErrorsTokenInvalid)
 	}
 	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
+		if !j.verifyAudience(claims.Audience) {
+			return nil, jwt.ErrTokenInvalidAudience
+		}
 		return claims, nil
 	} else {
 		return nil, errors.New(ErrorsTokenInvalid)
 	}
 }
 
+func (j *JwtSign) parse(tokenString string, key []byte) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	}, j.parserOptions()...)
+}
+
 // 更新token有效期
 func (j *JwtSign) RefreshToken(tokenString string, extraAddSeconds int64) (string, error) {
 	if customClaims, err := j.ParseToken(tokenString); err == nil {
@@ -53,3 +72,30 @@ func (j *JwtSign) RefreshToken(tokenString string, extraAddSeconds int64) (strin
 		return "", err
 	}
 }
+
+func (j *JwtSign) parserOptions() []jwt.ParserOption {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithLeeway(j.Leeway),
+		jwt.WithIssuedAt(),
+	}
+	if j.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(j.Issuer))
+	}
+	return opts
+}
+
+// 校验受众 token的aud包含任意一个配置的受众即通过
+func (j *JwtSign) verifyAudience(aud jwt.ClaimStrings) bool {
+	if len(j.Audience) == 0 {
+		return true
+	}
+	for _, want := range j.Audience {
+		for _, v := range aud {
+			if v == want {
+				return true
+			}
+		}
+	}
+	return false
+}
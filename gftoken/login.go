@@ -0,0 +1,321 @@
+package gftoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/crypto/gmd5"
+	"github.com/gogf/gf/v2/crypto/gsha1"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/grand"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	TokenCookieName        = "token"
+	RefreshTokenCookieName = "refresh_token"
+)
+
+// CredentialVerifier 登录凭证校验器
+type CredentialVerifier interface {
+	// Verify 校验用户名和密码，返回用户唯一标识及token需要携带的数据
+	// 用户唯一标识不足32位时将使用其md5值
+	Verify(ctx context.Context, username, password string) (userKey string, data interface{}, err error)
+}
+
+// CredentialVerifierFunc 函数形式的登录凭证校验器
+type CredentialVerifierFunc func(ctx context.Context, username, password string) (userKey string, data interface{}, err error)
+
+func (f CredentialVerifierFunc) Verify(ctx context.Context, username, password string) (string, interface{}, error) {
+	return f(ctx, username, password)
+}
+
+// LoginResult 登录结果
+type LoginResult struct {
+	Token        string `json:"token"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"` // token有效时长（秒）
+	ExpiresAt    int64  `json:"expiresAt"` // token失效时间戳
+	RefreshToken string `json:"refreshToken"`
+}
+
+// LoginResponse 登录接口响应
+type LoginResponse struct {
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Data    *LoginResult `json:"data,omitempty"`
+}
+
+// 刷新令牌缓存数据
+type refreshData struct {
+	Key     string      `json:"key"`
+	Scope   string      `json:"scope"`
+	Roles   []string    `json:"roles,omitempty"`
+	Data    interface{} `json:"data"`
+	Session string      `json:"session,omitempty"` // 签发刷新令牌的会话缓存key
+}
+
+// LoginHandler 登录接口，使用verifier校验表单参数username、password，成功后签发token及刷新令牌
+// 开启 WithLoginCookie 时同时将token及刷新令牌写入cookie
+// 开启 WithLoginThrottle 时按账号及客户端IP限制登录失败次数，部署在代理之后时需通过 WithTrustedProxies 设置代理地址
+func (m *GfToken) LoginHandler(verifier CredentialVerifier) ghttp.HandlerFunc {
+	return func(r *ghttp.Request) {
+		// 登录失败限制按租户统计
+		if v, err := m.scoped(r.GetCtx(), ""); err != nil || v != m {
+			if err != nil {
+				g.Log().Error(r.GetCtx(), err)
+				r.Response.WriteJson(AuthFailed{
+					Code:    FailedAuthCode,
+					Message: "登录失败",
+				})
+				return
+			}
+			v.LoginHandler(verifier)(r)
+			return
+		}
+		var (
+			ctx      = r.GetCtx()
+			username = r.Get("username").String()
+			ip       = m.clientIp(ctx)
+		)
+		locked, retryAfter, err := m.CheckThrottle(ctx, username, ip)
+		if err != nil {
+			g.Log().Error(ctx, err)
+		}
+		if locked {
+			seconds := int64(math.Ceil(retryAfter.Seconds()))
+			r.Response.Header().Set("Retry-After", gconv.String(seconds))
+			r.Response.WriteJson(AuthFailed{
+				Code:    ThrottledCode,
+				Message: fmt.Sprintf("登录失败次数过多，请%d秒后重试", seconds),
+			})
+			return
+		}
+		userKey, data, err := verifier.Verify(ctx, username, r.Get("password").String())
+		if err != nil {
+			g.Log().Info(ctx, "[GFToken]login failed:", err)
+			e := newEvent(EventAuthFailed, nil, err)
+			e.Account = username
+			m.emit(ctx, e)
+			if err = m.RecordLoginFailure(ctx, username, ip); err != nil {
+				g.Log().Error(ctx, err)
+			}
+			r.Response.WriteJson(AuthFailed{
+				Code:    FailedAuthCode,
+				Message: "用户名或密码错误",
+			})
+			return
+		}
+		if err = m.RecordLoginSuccess(ctx, username); err != nil {
+			g.Log().Error(ctx, err)
+		}
+		res, err := m.Login(ctx, userKey, data)
+		if err != nil {
+			message := "登录失败"
+			if errors.Is(err, ErrSessionLimitExceeded) {
+				message = ErrorsSessionLimit
+			} else {
+				g.Log().Error(ctx, err)
+			}
+			r.Response.WriteJson(AuthFailed{
+				Code:    FailedAuthCode,
+				Message: message,
+			})
+			return
+		}
+		m.setLoginCookie(r, res)
+		r.Response.WriteJson(LoginResponse{
+			Code:    0,
+			Message: "登录成功",
+			Data:    res,
+		})
+	}
+}
+
+// RefreshHandler 使用表单参数refresh_token(或cookie)换取新的token，旧token及刷新令牌同时失效
+// 由于携带的token可能已过期，该接口需挂载在 Middleware 之前或加入 ExcludePaths
+func (m *GfToken) RefreshHandler(r *ghttp.Request) {
+	ctx := r.GetCtx()
+	refreshToken := r.Get("refresh_token").String()
+	if refreshToken == "" {
+		refreshToken = r.Cookie.Get(RefreshTokenCookieName).String()
+	}
+	res, err := m.ExchangeRefreshToken(ctx, refreshToken)
+	if err != nil {
+		g.Log().Info(ctx, "[GFToken]refresh failed:", err)
+		r.Response.WriteJson(AuthFailed{
+			Code:    FailedAuthCode,
+			Message: "refresh_token已失效",
+		})
+		return
+	}
+	m.setLoginCookie(r, res)
+	r.Response.WriteJson(LoginResponse{
+		Code:    0,
+		Message: "刷新成功",
+		Data:    res,
+	})
+}
+
+// Login 为用户签发token及刷新令牌
+func (m *GfToken) Login(ctx context.Context, userKey string, data interface{}) (res *LoginResult, err error) {
+	scope, roles := dataGrant(data)
+	if len(userKey) < 32 {
+		userKey = gmd5.MustEncrypt(userKey)
+	}
+	return m.login(ctx, refreshData{
+		Key:   userKey,
+		Scope: scope,
+		Roles: roles,
+		Data:  data,
+	})
+}
+
+// ExchangeRefreshToken 使用刷新令牌换取新的token及刷新令牌，旧token及刷新令牌同时失效
+// 刷新令牌只能使用一次，并发换取时只有一个成功
+func (m *GfToken) ExchangeRefreshToken(ctx context.Context, refreshToken string) (res *LoginResult, err error) {
+	if refreshToken == "" {
+		err = gerror.New("refresh token empty")
+		return
+	}
+	// 刷新令牌与token相同以租户标识开头
+	if v, e := m.scoped(ctx, refreshToken); e != nil || v != m {
+		if e != nil {
+			return nil, e
+		}
+		return v.ExchangeRefreshToken(ctx, refreshToken)
+	}
+	var (
+		cacheKey = m.refreshCacheKey(refreshToken)
+		rData    *refreshData
+	)
+	result, err := m.takeCache(ctx, cacheKey)
+	if err != nil {
+		return
+	}
+	if result.IsEmpty() {
+		err = gerror.New("refresh token is invalid")
+		return
+	}
+	if err = result.Scan(&rData); err != nil {
+		return
+	}
+	if _, err = m.endRefreshSession(ctx, rData.Session, cacheKey); err != nil {
+		return
+	}
+	return m.login(ctx, *rData)
+}
+
+// RevokeRefreshToken 撤销刷新令牌，签发该刷新令牌的会话同时失效并触发撤销事件
+func (m *GfToken) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	if refreshToken == "" {
+		return gerror.New("refresh token empty")
+	}
+	if v, e := m.scoped(ctx, refreshToken); e != nil || v != m {
+		if e != nil {
+			return e
+		}
+		return v.RevokeRefreshToken(ctx, refreshToken)
+	}
+	var (
+		cacheKey = m.refreshCacheKey(refreshToken)
+		rData    *refreshData
+	)
+	result, err := m.takeCache(ctx, cacheKey)
+	if err != nil {
+		return err
+	}
+	if result.IsEmpty() {
+		return gerror.New("refresh token is invalid")
+	}
+	if err = result.Scan(&rData); err != nil {
+		return err
+	}
+	tData, err := m.endRefreshSession(ctx, rData.Session, cacheKey)
+	if err != nil || tData == nil {
+		return err
+	}
+	claims, _ := m.jwt().ParseToken(tData.JwtToken)
+	m.emit(ctx, newEvent(EventRevoke, claims, nil))
+	return nil
+}
+
+// 删除签发刷新令牌的会话，会话已被删除或顶替时不处理，返回被删除的会话数据
+func (m *GfToken) endRefreshSession(ctx context.Context, session, cacheKey string) (*TokenData, error) {
+	if session == "" {
+		return nil, nil
+	}
+	tData, err := m.getCache(ctx, m.CacheKey+session)
+	if err != nil || tData == nil || tData.RefreshToken != cacheKey {
+		return nil, err
+	}
+	if err = m.removeCache(ctx, m.CacheKey+session); err != nil {
+		return nil, err
+	}
+	if m.indexSessions() {
+		if err = m.dropSession(ctx, session); err != nil {
+			return nil, err
+		}
+	}
+	return tData, nil
+}
+
+func (m *GfToken) login(ctx context.Context, rData refreshData) (res *LoginResult, err error) {
+	if v, e := m.scoped(ctx, ""); e != nil || v != m {
+		if e != nil {
+			return nil, e
+		}
+		return v.login(ctx, rData)
+	}
+	var (
+		refreshToken = m.tenantPrefix() + grand.S(32)
+		cacheKey     = m.refreshCacheKey(refreshToken)
+		token        string
+		conf         = m.Current()
+	)
+	token, rData.Session, err = m.generateToken(ctx, rData.Key, rData.Data, rData.Scope, rData.Roles, cacheKey)
+	if err != nil {
+		return
+	}
+	err = m.cache.Set(ctx, cacheKey, rData, time.Duration(m.RefreshTimeout)*time.Second)
+	if err != nil {
+		return
+	}
+	res = &LoginResult{
+		Token:        token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(conf.ttl().Seconds()),
+		ExpiresAt:    conf.diedLine().Unix(),
+		RefreshToken: refreshToken,
+	}
+	return
+}
+
+// 刷新令牌缓存key，缓存中只保存刷新令牌的摘要
+func (m *GfToken) refreshCacheKey(refreshToken string) string {
+	return m.CacheKey + "refresh_" + gsha1.Encrypt(refreshToken)
+}
+
+func (m *GfToken) setLoginCookie(r *ghttp.Request, res *LoginResult) {
+	if !m.loginCookie {
+		return
+	}
+	var (
+		domain  = r.Server.GetCookieDomain()
+		path    = r.Server.GetCookiePath()
+		options = ghttp.CookieOptions{
+			SameSite: http.SameSiteLaxMode,
+			Secure:   m.loginCookieSecure || r.TLS != nil,
+			HttpOnly: true,
+		}
+	)
+	r.Cookie.SetCookie(TokenCookieName, res.Token, domain, path,
+		time.Duration(res.ExpiresIn)*time.Second, options)
+	r.Cookie.SetCookie(RefreshTokenCookieName, res.RefreshToken, domain, path,
+		time.Duration(m.RefreshTimeout)*time.Second, options)
+}
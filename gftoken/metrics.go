@@ -0,0 +1,152 @@
+package gftoken
+
+import (
+	"context"
+	"errors"
+	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/util/gconv"
+	"strings"
+	"time"
+)
+
+// 指标中的操作名称
+const (
+	OpGenerate      = "generate"
+	OpValidate      = "validate"
+	OpRefresh       = "refresh"
+	OpRemove        = "remove"
+	OpCacheGet      = "cache_get"
+	OpCacheSet      = "cache_set"
+	OpCacheRemove   = "cache_remove"
+	OpCacheContains = "cache_contains"
+)
+
+// 指标中的操作结果
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Metrics 指标收集接口，Prometheus实现见 metrics 包
+type Metrics interface {
+	// ObserveOperation 记录一次操作的结果及耗时，reason为失败原因，成功时为空
+	ObserveOperation(server, op, outcome, reason string, duration time.Duration)
+}
+
+// FailureReason 将错误转换为取值有限的失败原因，用作指标标签
+func FailureReason(err error) string {
+	var replaced *SessionReplacedError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrTokenRevoked):
+		return "revoked"
+	case errors.Is(err, ErrRealmMismatch):
+		return "realm_mismatch"
+	case errors.Is(err, ErrTokenInvalid):
+		return "invalid"
+	case errors.Is(err, ErrTokenExpired):
+		return "expired"
+	case errors.Is(err, ErrDeviceMismatch):
+		return "device_mismatch"
+	case errors.Is(err, ErrSessionLimitExceeded):
+		return "session_limit"
+	case errors.Is(err, ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, ErrTenantMismatch):
+		return "tenant_mismatch"
+	case errors.As(err, &replaced):
+		return "replaced"
+	}
+	return "error"
+}
+
+// 记录操作指标，err为指针以便在defer中读取最终的返回值
+func (m *GfToken) observe(op string, start time.Time, err *error) {
+	if m.metrics == nil {
+		return
+	}
+	var (
+		outcome = OutcomeSuccess
+		reason  string
+	)
+	if err != nil && *err != nil {
+		outcome = OutcomeFailure
+		reason = FailureReason(*err)
+	}
+	m.metrics.ObserveOperation(m.ServerName, op, outcome, reason, time.Since(start))
+}
+
+// CountSessions 统计缓存中未过期的会话数，包含已超时但仍可刷新的会话；
+// 只遍历缓存key，不读取会话内容，也不记录缓存操作指标，redis使用SCAN分批遍历。
+// 租户会话计入实例，不解析租户配置
+func (m *GfToken) CountSessions(ctx context.Context) (count int, err error) {
+	keys, err := m.cacheKeys(ctx)
+	if err != nil {
+		return
+	}
+	internal := []string{"refresh_", "ratelimit_", "replaced_", "sessions_", "throttle_"}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, m.CacheKey) {
+			continue
+		}
+		name := key[len(m.CacheKey):]
+		if m.tenants != nil && m.tenant == "" {
+			if _, rest, ok := strings.Cut(name, ":"); ok {
+				name = rest
+			}
+		}
+		if len(name) < 32 || hasAnyPrefix(name, internal) {
+			continue
+		}
+		count++
+	}
+	return
+}
+
+// 实例CacheKey前缀下的全部缓存key
+func (m *GfToken) cacheKeys(ctx context.Context) (keys []string, err error) {
+	if m.redis == nil {
+		var all []interface{}
+		if all, err = m.cache.Keys(ctx); err != nil {
+			return
+		}
+		keys = gconv.Strings(all)
+		return
+	}
+	var (
+		cursor uint64
+		batch  []string
+		option = gredis.ScanOption{Match: redisGlobEscape(m.CacheKey) + "*", Count: 1000}
+	)
+	for {
+		if cursor, batch, err = m.redis.Scan(ctx, cursor, option); err != nil {
+			return
+		}
+		keys = append(keys, batch...)
+		if cursor == 0 {
+			return
+		}
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// 转义redis MATCH中的通配符
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -6,48 +6,65 @@ import (
 	"strings"
 )
 
-// Middleware 绑定group
+// Middleware 绑定group，认证通过后可通过 IdentityFromContext 获取用户身份
 func (m *GfToken) Middleware(group *ghttp.RouterGroup) error {
 	group.Middleware(m.authMiddleware)
 	return nil
 }
 
 func (m *GfToken) authMiddleware(r *ghttp.Request) {
-	b, res := m.IsLogin(r)
-	if !b {
+	// 识别的租户同样用于后续处理中签发token
+	r.SetCtx(m.withRequestTenant(r.GetCtx(), r.Request))
+	// 认证过程在独立的span中进行，结束后恢复原上下文
+	parent := r.GetCtx()
+	ctx, span := m.startSpan(parent, spanAuthMiddleware)
+	r.SetCtx(ctx)
+	identity, res, err := m.checkLogin(r)
+	endSpan(span, &err)
+	r.SetCtx(parent)
+	if res != nil {
+		if m.codeError {
+			// 交由 ghttp.MiddlewareHandlerResponse 等响应中间件输出
+			r.SetError(authError(r.GetCtx(), err, res))
+			return
+		}
 		r.Response.WriteJson(res)
 		return
 	}
+	if identity != nil {
+		r.SetCtx(ContextWithIdentity(r.GetCtx(), identity))
+	}
 	r.Middleware.Next()
 }
 
 // AuthPath 判断路径是否需要进行认证拦截
 // return true 需要认证
 func (m *GfToken) AuthPath(urlPath string) bool {
+	// 排除路径处理，到这里nextFlag为true
+	for _, excludePath := range m.Current().ExcludePaths {
+		if matchPath(urlPath, excludePath) {
+			// 匹配排除路径不拦截
+			return false
+		}
+	}
+	return true
+}
+
+// 判断路径是否匹配规则，规则以/*结尾时为前缀匹配，否则为全路径匹配
+func matchPath(urlPath, pattern string) bool {
 	// 去除后斜杠
 	if strings.HasSuffix(urlPath, "/") {
 		urlPath = gstr.SubStr(urlPath, 0, len(urlPath)-1)
 	}
-	// 排除路径处理，到这里nextFlag为true
-	for _, excludePath := range m.ExcludePaths {
-		tmpPath := excludePath
-		// 前缀匹配
-		if strings.HasSuffix(tmpPath, "/*") {
-			tmpPath = gstr.SubStr(tmpPath, 0, len(tmpPath)-2)
-			if gstr.HasPrefix(urlPath, tmpPath) {
-				// 前缀匹配不拦截
-				return false
-			}
-		} else {
-			// 全路径匹配
-			if strings.HasSuffix(tmpPath, "/") {
-				tmpPath = gstr.SubStr(tmpPath, 0, len(tmpPath)-1)
-			}
-			if urlPath == tmpPath {
-				// 全路径匹配不拦截
-				return false
-			}
-		}
+	tmpPath := pattern
+	// 前缀匹配
+	if strings.HasSuffix(tmpPath, "/*") {
+		tmpPath = gstr.SubStr(tmpPath, 0, len(tmpPath)-2)
+		return gstr.HasPrefix(urlPath, tmpPath)
 	}
-	return true
+	// 全路径匹配
+	if strings.HasSuffix(tmpPath, "/") {
+		tmpPath = gstr.SubStr(tmpPath, 0, len(tmpPath)-1)
+	}
+	return urlPath == tmpPath
 }
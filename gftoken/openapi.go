@@ -0,0 +1,171 @@
+package gftoken
+
+import (
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/net/goai"
+	"github.com/gogf/gf/v2/text/gstr"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// OpenAPI安全方案名称，与 GetRequestToken 支持的token来源对应
+const (
+	SecuritySchemeBearer = "BearerAuth" // 请求头 Authorization: Bearer token
+	SecuritySchemeCookie = "CookieAuth" // cookie token
+	SecuritySchemeQuery  = "QueryAuth"  // 查询参数 token
+)
+
+// 接口所需角色及授权范围的OpenAPI扩展字段
+// OpenAPI 3.0 中http及apiKey类型的安全要求只能使用空的scopes，授权范围写入扩展字段
+const (
+	openApiRolesExtension  = "x-roles"
+	openApiScopesExtension = "x-scopes"
+)
+
+// BindOpenApi 绑定到服务的OpenAPI文档地址，首次请求文档时写入token安全方案及各接口的安全要求
+// 需在服务启动前调用，openApiPath与 SetOpenApiPath 设置的地址一致
+func (m *GfToken) BindOpenApi(s *ghttp.Server, openApiPath string) {
+	var once sync.Once
+	s.BindHookHandler(openApiPath, ghttp.HookBeforeServe, func(r *ghttp.Request) {
+		once.Do(func() {
+			m.AnnotateOpenApi(s.GetOpenApi(), s.GetRoutes())
+		})
+	})
+}
+
+// AnnotateOpenApi 在OpenAPI文档中注册token安全方案，并为经过认证中间件的规范路由添加安全要求
+// 是否需要认证按路由元数据及ExcludePaths判断，授权范围写入x-scopes扩展字段，角色写入x-roles扩展字段；
+// 只处理通过 Middleware 或 MultiRealm.Middleware 绑定认证中间件的路由
+func (m *GfToken) AnnotateOpenApi(oai *goai.OpenApiV3, routes []ghttp.RouterItem) {
+	if oai.Components.SecuritySchemes == nil {
+		oai.Components.SecuritySchemes = goai.SecuritySchemes{}
+	}
+	oai.Components.SecuritySchemes[SecuritySchemeBearer] = goai.SecuritySchemeRef{Value: &goai.SecurityScheme{
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: m.ServerName + " token",
+	}}
+	oai.Components.SecuritySchemes[SecuritySchemeCookie] = goai.SecuritySchemeRef{Value: &goai.SecurityScheme{
+		Type: "apiKey",
+		In:   "cookie",
+		Name: TokenCookieName,
+	}}
+	oai.Components.SecuritySchemes[SecuritySchemeQuery] = goai.SecuritySchemeRef{Value: &goai.SecurityScheme{
+		Type: "apiKey",
+		In:   "query",
+		Name: "token",
+	}}
+	// 全局中间件方式绑定的认证中间件
+	var global []string
+	for _, item := range routes {
+		if item.Type == ghttp.HandlerTypeMiddleware && item.Handler != nil && isAuthMiddleware(item.Handler.Info.Func) {
+			global = append(global, item.Route)
+		}
+	}
+	for _, item := range routes {
+		if item.Type == ghttp.HandlerTypeMiddleware || item.Type == ghttp.HandlerTypeHook {
+			continue
+		}
+		if item.Handler == nil || !item.Handler.Info.IsStrictRoute {
+			continue
+		}
+		if !hasAuthMiddleware(item, global) {
+			continue
+		}
+		path, ok := oai.Paths[item.Route]
+		if !ok {
+			continue
+		}
+		meta := routeMetaOfFunc(item.Handler.Info.Type)
+		auth := m.authMode(item.Route, meta)
+		for _, operation := range pathOperations(&path, item.Method) {
+			if auth == AuthNone {
+				empty := goai.SecurityRequirements{}
+				operation.Security = &empty
+				continue
+			}
+			operation.Security = securityRequirements(auth == AuthOptional)
+			if meta == nil {
+				continue
+			}
+			if len(meta.Roles) > 0 {
+				setExtension(operation, openApiRolesExtension, strings.Join(meta.Roles, ","))
+			}
+			if len(meta.Scopes) > 0 {
+				setExtension(operation, openApiScopesExtension, strings.Join(meta.Scopes, ","))
+			}
+		}
+		oai.Paths[item.Route] = path
+	}
+}
+
+// 路由是否经过认证中间件，global为全局认证中间件的路由规则
+func hasAuthMiddleware(item ghttp.RouterItem, global []string) bool {
+	for _, h := range item.Handler.Middleware {
+		if isAuthMiddleware(h) {
+			return true
+		}
+	}
+	for _, pattern := range global {
+		if pattern == "/*" || strings.HasPrefix(item.Route, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否为 GfToken 或 MultiRealm 的认证中间件
+func isAuthMiddleware(h ghttp.HandlerFunc) bool {
+	if h == nil {
+		return false
+	}
+	p := reflect.ValueOf(h).Pointer()
+	return p == reflect.ValueOf((*GfToken)(nil).authMiddleware).Pointer() ||
+		p == reflect.ValueOf((*MultiRealm)(nil).authMiddleware).Pointer()
+}
+
+func setExtension(operation *goai.Operation, name, value string) {
+	if operation.XExtensions == nil {
+		operation.XExtensions = goai.XExtensions{}
+	}
+	operation.XExtensions[name] = value
+}
+
+// 任意一种token来源均可，可选认证时允许不携带token
+func securityRequirements(optional bool) *goai.SecurityRequirements {
+	requirements := goai.SecurityRequirements{
+		{SecuritySchemeBearer: []string{}},
+		{SecuritySchemeCookie: []string{}},
+		{SecuritySchemeQuery: []string{}},
+	}
+	if optional {
+		requirements = append(requirements, goai.SecurityRequirement{})
+	}
+	return &requirements
+}
+
+// 路由方法对应的接口，ALL对应全部已生成的接口
+func pathOperations(path *goai.Path, method string) []*goai.Operation {
+	operations := map[string]*goai.Operation{
+		"GET":     path.Get,
+		"POST":    path.Post,
+		"PUT":     path.Put,
+		"DELETE":  path.Delete,
+		"PATCH":   path.Patch,
+		"HEAD":    path.Head,
+		"OPTIONS": path.Options,
+		"CONNECT": path.Connect,
+		"TRACE":   path.Trace,
+	}
+	var result []*goai.Operation
+	for name, operation := range operations {
+		if operation == nil {
+			continue
+		}
+		if gstr.Equal(method, "ALL") || gstr.Equal(method, name) {
+			result = append(result, operation)
+		}
+	}
+	return result
+}
@@ -1,36 +1,81 @@
 package gftoken
 
 import (
+	"context"
 	_ "github.com/gogf/gf/contrib/nosql/redis/v2"
 	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/errors/gerror"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gcache"
+	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/tiger1103/gfast-token/adapter"
+	"net"
+	"strings"
+	"time"
+)
+
+// 默认密钥，仅用于开发环境
+const (
+	defaultSigningKey = "defaultGFToken"
+	defaultEncryptKey = "49c54195e750b04e74a8429b17aefc77"
 )
 
 var (
 	defaultGFToken = GfToken{
-		ServerName: "defaultGFToken",
-		CacheKey:   "defaultGFToken_",
-		Timeout:    60 * 60 * 24 * 10,
-		MaxRefresh: 60 * 60 * 24 * 5,
-		cache:      gcache.New(),
-		userJwt:    CreateMyJWT("defaultGFToken"),
-		MultiLogin: false,
-		EncryptKey: []byte("49c54195e750b04e74a8429b17aefc77"),
+		ServerName:      "defaultGFToken",
+		CacheKey:        "defaultGFToken_",
+		Timeout:         60 * 60 * 24 * 10,
+		MaxRefresh:      60 * 60 * 24 * 5,
+		RefreshTimeout:  60 * 60 * 24 * 30,
+		ReplacedTimeout: 60 * 60 * 24,
+		cache:           gcache.New(),
+		userJwt:         CreateMyJWT(defaultSigningKey),
+		MultiLogin:      false,
+		EncryptKey:      []byte(defaultEncryptKey),
+		Leeway:          10,
 	}
 )
 
 type OptionFunc func(*GfToken)
 
+// NewGfToken 创建实例，未设置缓存的实例共用同一个内存缓存
+// 生产环境建议使用 NewGfTokenE
 func NewGfToken(opts ...OptionFunc) *GfToken {
 	g := defaultGFToken
 	for _, o := range opts {
 		o(&g)
 	}
+	g.initSnapshot()
+	g.logSecretErr()
+	g.startSecretRefresh()
 	return &g
 }
 
+// NewGfTokenE 创建实例并校验配置，每个实例使用独立的内存缓存
+// 未通过 WithUserJwt、WithEncryptKey 设置密钥时返回错误，开发环境可使用 WithDefaultSecrets 允许默认密钥
+func NewGfTokenE(opts ...OptionFunc) (gft *GfToken, err error) {
+	g := defaultGFToken
+	g.cache = gcache.New()
+	defer func() {
+		// 部分选项(如WithGRedisConfig)在配置错误时panic
+		if e := recover(); e != nil {
+			gft, err = nil, gerror.Newf("invalid token option: %v", e)
+		}
+	}()
+	for _, o := range opts {
+		o(&g)
+	}
+	if g.secretErr != nil {
+		return nil, g.secretErr
+	}
+	g.initSnapshot()
+	if err = g.Validate(); err != nil {
+		return nil, err
+	}
+	g.startSecretRefresh()
+	return &g, nil
+}
+
 func WithExcludePaths(value g.SliceStr) OptionFunc {
 	return func(g *GfToken) {
 		g.ExcludePaths = value
@@ -83,6 +128,7 @@ func WithUserJwt(key string) OptionFunc {
 func WithGCache() OptionFunc {
 	return func(g *GfToken) {
 		g.cache = gcache.New()
+		g.redis = nil
 	}
 }
 
@@ -90,16 +136,18 @@ func WithGRedis(redis ...*gredis.Redis) OptionFunc {
 	return func(gf *GfToken) {
 		gf.cache = gcache.New()
 		if len(redis) > 0 {
-			gf.cache.SetAdapter(gcache.NewAdapterRedis(redis[0]))
+			gf.redis = redis[0]
 		} else {
-			gf.cache.SetAdapter(gcache.NewAdapterRedis(g.Redis()))
+			gf.redis = g.Redis()
 		}
+		gf.cache.SetAdapter(gcache.NewAdapterRedis(gf.redis))
 	}
 }
 
 func WithDist(dist ...*adapter.Dist) OptionFunc {
 	return func(gf *GfToken) {
 		gf.cache = gcache.New()
+		gf.redis = nil
 		if len(dist) > 0 {
 			gf.cache.SetAdapter(dist[0])
 		} else {
@@ -115,6 +163,7 @@ func WithGRedisConfig(redisConfig ...*gredis.Config) OptionFunc {
 		if err != nil {
 			panic(err)
 		}
+		g.redis = redis
 		g.cache.SetAdapter(gcache.NewAdapterRedis(redis))
 	}
 }
@@ -122,6 +171,7 @@ func WithGRedisConfig(redisConfig ...*gredis.Config) OptionFunc {
 func WithDistConfig(distConfig *adapter.Config) OptionFunc {
 	return func(g *GfToken) {
 		g.cache = gcache.New()
+		g.redis = nil
 		adapter.SetConfig(distConfig)
 		dist := adapter.New()
 		g.cache.SetAdapter(dist)
@@ -133,3 +183,247 @@ func WithMultiLogin(b bool) OptionFunc {
 		g.MultiLogin = b
 	}
 }
+
+// WithIssuer 设置jwt签发者，解析token时校验iss
+func WithIssuer(value string) OptionFunc {
+	return func(g *GfToken) {
+		g.Issuer = value
+	}
+}
+
+// WithAudience 设置jwt受众，解析token时校验aud
+func WithAudience(value ...string) OptionFunc {
+	return func(g *GfToken) {
+		g.Audience = value
+	}
+}
+
+// WithLeeway 设置校验jwt时间时允许的时钟偏差（秒）
+func WithLeeway(value int64) OptionFunc {
+	return func(g *GfToken) {
+		g.Leeway = value
+	}
+}
+
+// WithClientCredentials 添加允许调用token内省、撤销接口的客户端凭证
+func WithClientCredentials(clientId, clientSecret string) OptionFunc {
+	return func(g *GfToken) {
+		clients := make(map[string]string, len(g.clients)+1)
+		for k, v := range g.clients {
+			clients[k] = v
+		}
+		clients[clientId] = clientSecret
+		g.clients = clients
+	}
+}
+
+// WithLogoutResponse 设置 LogoutHandler 的响应内容
+func WithLogoutResponse(value interface{}) OptionFunc {
+	return func(g *GfToken) {
+		g.logoutResponse = value
+	}
+}
+
+// WithRevokeResponse 设置 RevokeHandler 的响应内容
+func WithRevokeResponse(value interface{}) OptionFunc {
+	return func(g *GfToken) {
+		g.revokeResponse = value
+	}
+}
+
+// WithRefreshTimeout 设置刷新令牌有效期（秒）
+func WithRefreshTimeout(value int64) OptionFunc {
+	return func(g *GfToken) {
+		g.RefreshTimeout = value
+	}
+}
+
+// WithLoginCookie 设置登录接口是否将token及刷新令牌写入cookie
+// secure为true时cookie始终设置Secure，用于在代理处终止TLS的部署，未设置时仅HTTPS请求设置
+func WithLoginCookie(b bool, secure ...bool) OptionFunc {
+	return func(g *GfToken) {
+		g.loginCookie = b
+		if len(secure) > 0 {
+			g.loginCookieSecure = secure[0]
+		}
+	}
+}
+
+// WithLoginThrottle 开启登录失败限制，按账号及客户端IP统计失败次数并锁定
+func WithLoginThrottle(config ...ThrottleConfig) OptionFunc {
+	return func(g *GfToken) {
+		c := ThrottleConfig{}
+		if len(config) > 0 {
+			c = config[0]
+		}
+		g.throttle = c.withDefault()
+	}
+}
+
+// WithSessionLimit 设置每个用户的会话数量限制，需同时开启多点登录，否则 NewGfTokenE 返回错误；
+// DeviceMax 按客户端可自行声明的 X-Device-Type 请求头计数，见 SessionLimit
+func WithSessionLimit(limit SessionLimit) OptionFunc {
+	return func(g *GfToken) {
+		g.sessionLimit = &limit
+	}
+}
+
+// WithDeviceTracking 设置多点登录时是否记录用户的会话列表，开启后可通过 GetSessions 查询全部会话
+func WithDeviceTracking(b bool) OptionFunc {
+	return func(g *GfToken) {
+		g.deviceTracking = b
+	}
+}
+
+// WithTrustedProxies 设置可信代理的IP或CIDR，如 "10.0.0.0/8"、"127.0.0.1"
+// 默认以直连地址作为客户端IP，只有直连地址属于可信代理时才读取X-Forwarded-For、X-Real-IP，
+// 避免客户端伪造请求头绕过或滥用按IP的登录失败限制
+func WithTrustedProxies(proxies ...string) OptionFunc {
+	return func(g *GfToken) {
+		networks := make([]*net.IPNet, 0, len(proxies))
+		for _, proxy := range proxies {
+			if !strings.Contains(proxy, "/") {
+				if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+					proxy += "/32"
+				} else {
+					proxy += "/128"
+				}
+			}
+			_, network, err := net.ParseCIDR(proxy)
+			if err != nil {
+				panic(gerror.Wrapf(err, "invalid trusted proxy %q", proxy))
+			}
+			networks = append(networks, network)
+		}
+		g.trustedProxies = networks
+	}
+}
+
+// WithDeviceBinding 设置是否拒绝与登录时设备指纹不一致的请求
+func WithDeviceBinding(b bool) OptionFunc {
+	return func(g *GfToken) {
+		g.deviceBinding = b
+	}
+}
+
+// WithReplacedTimeout 设置会话被顶替的标记保留时间（秒），为0时被顶替的会话只提示token已失效
+func WithReplacedTimeout(value int64) OptionFunc {
+	return func(g *GfToken) {
+		g.ReplacedTimeout = value
+	}
+}
+
+// WithOnSessionReplaced 设置会话被新登录顶替时的回调
+func WithOnSessionReplaced(f func(ctx context.Context, replaced *SessionReplaced)) OptionFunc {
+	return func(g *GfToken) {
+		g.onSessionReplaced = f
+	}
+}
+
+// WithHook 注册同步执行的生命周期回调
+func WithHook(hook Hook) OptionFunc {
+	return func(g *GfToken) {
+		g.hooks = append(g.hooks[:len(g.hooks):len(g.hooks)], hookEntry{hook: hook})
+	}
+}
+
+// WithAsyncHook 注册异步执行的生命周期回调，workers为协程池大小，默认不限制
+func WithAsyncHook(hook Hook, workers ...int) OptionFunc {
+	return func(g *GfToken) {
+		g.hooks = append(g.hooks[:len(g.hooks):len(g.hooks)], hookEntry{
+			hook: hook,
+			pool: grpool.New(workers...),
+		})
+	}
+}
+
+// WithSecretProvider 从密钥提供者获取jwt签名key及token加密key，代替 WithUserJwt、WithEncryptKey
+// refresh大于0时定时重新获取，密钥变化时热更新，不再使用时需调用 Close 停止；
+// 获取失败时 NewGfTokenE 返回错误，NewGfToken 记录日志，RefreshSecrets成功前签发及校验token均返回 ErrSecretUnavailable
+func WithSecretProvider(provider SecretProvider, refresh ...time.Duration) OptionFunc {
+	return func(g *GfToken) {
+		ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+		defer cancel()
+		g.secretProvider = provider
+		signingKey, encryptKey, err := fetchSecrets(ctx, provider)
+		if err != nil {
+			g.secretErr = err
+		} else {
+			g.userJwt = &JwtSign{SigningKey: signingKey}
+			g.EncryptKey = encryptKey
+		}
+		if len(refresh) > 0 {
+			g.secretRefresh = refresh[0]
+		}
+	}
+}
+
+// WithCodeError 认证失败时不直接输出响应，而是通过 r.SetError 设置带错误码的gerror，
+// 由 ghttp.MiddlewareHandlerResponse 统一输出，错误信息可通过gi18n翻译
+func WithCodeError(b bool) OptionFunc {
+	return func(g *GfToken) {
+		g.codeError = b
+	}
+}
+
+// WithRealm 设置领域标识，签发的token以 "领域." 开头，供 MultiRealm 将token交由对应实例校验
+// 领域标识不能包含"."，启用前签发的token仍然有效
+func WithRealm(value string) OptionFunc {
+	return func(g *GfToken) {
+		g.realm = value
+	}
+}
+
+// WithTenants 开启多租户，token以 "租户~" 开头并在claims中记录租户，缓存key按租户隔离；
+// provider可为租户提供独立的密钥及超时时间，为nil时所有租户使用实例配置。
+// 租户实例在首次使用时创建，实例配置热更新时自动同步，租户配置变化后需调用 ReloadTenant
+func WithTenants(provider TenantConfigProvider) OptionFunc {
+	return func(g *GfToken) {
+		t := &tenantState{provider: provider}
+		if g.tenants != nil {
+			t.resolver = g.tenants.resolver
+		}
+		g.tenants = t
+	}
+}
+
+// WithTenantResolver 开启多租户并设置中间件识别请求租户的方式，如 HeaderTenant、HostTenant，
+// 请求携带其他租户的token时认证失败
+func WithTenantResolver(resolver TenantResolver) OptionFunc {
+	return func(g *GfToken) {
+		t := &tenantState{resolver: resolver}
+		if g.tenants != nil {
+			t.provider = g.tenants.provider
+		}
+		g.tenants = t
+	}
+}
+
+// WithDefaultSecrets 允许 NewGfTokenE 使用内置的默认密钥，仅用于开发环境
+func WithDefaultSecrets() OptionFunc {
+	return func(g *GfToken) {
+		g.defaultSecrets = true
+	}
+}
+
+// WithAudit 开启审计日志，记录登录、刷新、退出、撤销及认证失败事件，未指定输出时写入默认日志
+// 审计日志由单个协程按事件顺序异步写入，不阻塞请求，停止服务前调用 Close 等待写入完成；
+// 需要同步写入时使用 WithHook(NewAuditHook(sinks...))
+func WithAudit(sinks ...AuditSink) OptionFunc {
+	return WithAsyncHook(NewAuditHook(sinks...), 1)
+}
+
+// WithMetrics 设置指标收集，记录token生成、校验、刷新、删除及缓存操作的次数与耗时
+func WithMetrics(metrics Metrics) OptionFunc {
+	return func(g *GfToken) {
+		g.metrics = metrics
+	}
+}
+
+// WithTracing 开启OpenTelemetry链路追踪，记录认证中间件、token解密、缓存读写及jwt解析签发的span
+// 使用GoFrame全局的TracerProvider，span中不会记录token内容
+func WithTracing() OptionFunc {
+	return func(g *GfToken) {
+		g.tracing = true
+	}
+}
@@ -0,0 +1,146 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/util/gconv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RateLimit 限流规则，Window时间窗口（秒）内最多允许Limit次请求
+type RateLimit struct {
+	Limit  int64
+	Window int64
+}
+
+// RouteRateLimit 路由限流规则，Path规则同ExcludePaths，可使用 "POST:/path" 限定请求方法
+type RouteRateLimit struct {
+	Path string
+	RateLimit
+}
+
+// RateLimitConfig 限流配置
+type RateLimitConfig struct {
+	// 默认规则 Limit为0时不限制未匹配路由的请求
+	Default RateLimit
+	// 路由规则 按顺序匹配第一个规则，同一规则下的路由共用配额
+	Routes []RouteRateLimit
+	// 是否按会话限流 默认按用户限流(多点登录时同一用户的所有会话共用配额)
+	PerSession bool
+}
+
+// RateLimitResult 限流结果
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	Reset     int64 // 当前窗口结束时间戳
+}
+
+// RateLimitMiddleware 按token身份限流的中间件，需挂载在 Middleware 之后
+// 未携带有效token的请求不做限制
+func (m *GfToken) RateLimitMiddleware(config RateLimitConfig) ghttp.HandlerFunc {
+	return func(r *ghttp.Request) {
+		identity := m.rateLimitIdentity(r, config.PerSession)
+		pattern, limit := config.match(r.Method, r.URL.Path)
+		if identity == "" || limit.Limit <= 0 || limit.Window <= 0 {
+			r.Middleware.Next()
+			return
+		}
+		res, err := m.Allow(r.GetCtx(), identity+"_"+pattern, limit)
+		if err != nil {
+			// 限流存储异常时不影响正常请求
+			g.Log().Error(r.GetCtx(), err)
+			r.Middleware.Next()
+			return
+		}
+		header := r.Response.Header()
+		header.Set("X-RateLimit-Limit", gconv.String(res.Limit))
+		header.Set("X-RateLimit-Remaining", gconv.String(res.Remaining))
+		header.Set("X-RateLimit-Reset", gconv.String(res.Reset))
+		if !res.Allowed {
+			header.Set("Retry-After", gconv.String(res.Reset-time.Now().Unix()))
+			r.Response.WriteHeader(http.StatusTooManyRequests)
+			r.Response.WriteJson(AuthFailed{
+				Code:    ThrottledCode,
+				Message: "请求过于频繁",
+			})
+			return
+		}
+		r.Middleware.Next()
+	}
+}
+
+// Allow 滑动窗口计数限流，根据上一窗口的计数按时间比例估算当前滑动窗口内的请求数
+// Limit及Window须大于0
+func (m *GfToken) Allow(ctx context.Context, key string, limit RateLimit) (res *RateLimitResult, err error) {
+	if limit.Limit <= 0 || limit.Window <= 0 {
+		err = gerror.Newf("invalid rate limit: limit %d, window %d", limit.Limit, limit.Window)
+		return
+	}
+	var (
+		now     = time.Now()
+		window  = limit.Window
+		current = now.Unix() / window
+		elapsed = float64(now.UnixNano()%(window*int64(time.Second))) / float64(window*int64(time.Second))
+		prefix  = m.CacheKey + "ratelimit_" + key + "_"
+		count   int64
+		prev    int64
+	)
+	count, err = m.incr(ctx, prefix+gconv.String(current), time.Duration(window*2)*time.Second)
+	if err != nil {
+		return
+	}
+	prev, err = m.counter(ctx, prefix+gconv.String(current-1))
+	if err != nil {
+		return
+	}
+	estimated := int64(float64(prev)*(1-elapsed)) + count
+	res = &RateLimitResult{
+		Allowed:   estimated <= limit.Limit,
+		Limit:     limit.Limit,
+		Remaining: limit.Limit - estimated,
+		Reset:     (current + 1) * window,
+	}
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	return
+}
+
+// 限流身份 按会话时为token对应的缓存key，按用户时去除多点登录的随机后缀
+func (m *GfToken) rateLimitIdentity(r *ghttp.Request, perSession bool) string {
+	token := m.GetRequestToken(r)
+	if token == "" {
+		return ""
+	}
+	key, _, err := m.DecryptToken(r.GetCtx(), token)
+	if err != nil {
+		return ""
+	}
+	if !perSession && m.MultiLogin && len(key) > 16 {
+		key = key[:len(key)-16]
+	}
+	return key
+}
+
+// 匹配路由规则，返回规则路径及限流配置
+func (c *RateLimitConfig) match(method, urlPath string) (string, RateLimit) {
+	for _, route := range c.Routes {
+		path := route.Path
+		if m, p, ok := strings.Cut(path, ":"); ok && !strings.Contains(m, "/") {
+			if !strings.EqualFold(m, method) {
+				continue
+			}
+			path = p
+		}
+		if matchPath(urlPath, path) {
+			return route.Path, route.RateLimit
+		}
+	}
+	return "*", c.Default
+}
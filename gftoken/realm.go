@@ -0,0 +1,77 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"strings"
+)
+
+// RealmSeparator 领域标识与token之间的分隔符，base64编码的token中不会出现该字符
+const RealmSeparator = "."
+
+// TokenRealm 获取token携带的领域标识，未携带时返回空字符串
+func TokenRealm(token string) string {
+	realm, _ := splitRealm(token)
+	return realm
+}
+
+func splitRealm(token string) (realm, rest string) {
+	if i := strings.Index(token, RealmSeparator); i > 0 {
+		return token[:i], token[i+1:]
+	}
+	return "", token
+}
+
+// MultiRealm 多个实例共同认证，根据token的领域标识交由对应实例校验，
+// 未携带领域标识的token由第一个未设置领域的实例校验
+//
+//	admin := gftoken.NewGfToken(gftoken.WithRealm("admin"), gftoken.WithCacheKey("admin_"))
+//	app := gftoken.NewGfToken(gftoken.WithRealm("app"), gftoken.WithCacheKey("app_"))
+//	gftoken.NewMultiRealm(admin, app).Middleware(group)
+type MultiRealm struct {
+	tokens []*GfToken
+	realms map[string]*GfToken
+}
+
+// NewMultiRealm 创建多领域认证，未携带token的请求按第一个实例的排除地址及路由元数据处理，
+// 至少需要一个实例，否则panic
+func NewMultiRealm(tokens ...*GfToken) *MultiRealm {
+	if len(tokens) == 0 {
+		panic(gerror.New("NewMultiRealm requires at least one GfToken instance"))
+	}
+	mr := &MultiRealm{
+		tokens: tokens,
+		realms: make(map[string]*GfToken, len(tokens)),
+	}
+	for _, m := range tokens {
+		if _, ok := mr.realms[m.realm]; !ok {
+			mr.realms[m.realm] = m
+		}
+	}
+	return mr
+}
+
+// Realm 获取负责校验token的实例，token为空或领域未注册时返回第一个实例
+func (mr *MultiRealm) Realm(token string) *GfToken {
+	if m, ok := mr.realms[TokenRealm(token)]; ok && token != "" {
+		return m
+	}
+	return mr.tokens[0]
+}
+
+// VerifyToken 由对应领域的实例校验token，返回的 Identity.Realm 为认证通过的领域
+func (mr *MultiRealm) VerifyToken(ctx context.Context, token string) (*Identity, error) {
+	return mr.Realm(token).VerifyToken(ctx, token)
+}
+
+// Middleware 绑定group，认证通过后可通过 IdentityFromContext 获取用户身份及认证的领域
+func (mr *MultiRealm) Middleware(group *ghttp.RouterGroup) error {
+	group.Middleware(mr.authMiddleware)
+	return nil
+}
+
+func (mr *MultiRealm) authMiddleware(r *ghttp.Request) {
+	// 各实例读取token的方式相同
+	mr.Realm(mr.tokens[0].GetRequestToken(r)).authMiddleware(r)
+}
@@ -0,0 +1,321 @@
+package gftoken
+
+import (
+	"bytes"
+	"context"
+	"github.com/gogf/gf/v2/crypto/gaes"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/os/gfsnotify"
+	"github.com/gogf/gf/v2/text/gstr"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 轮换后保留的历史key数量
+const maxPrevKeys = 5
+
+// Snapshot 可热更新的配置快照，发布后不可修改
+// 请求处理过程中只读取一次快照，热更新不会影响进行中的请求
+type Snapshot struct {
+	Timeout      int64
+	MaxRefresh   int64
+	ExcludePaths g.SliceStr
+	SigningKey   []byte // 签发jwt使用的key
+	EncryptKey   []byte // 加密token使用的key
+	// 轮换前使用的key，仅用于校验和解密已签发的token，保证更换key后已登录的用户不受影响
+	PrevSigningKeys [][]byte
+	PrevEncryptKeys [][]byte
+	jwt             *JwtSign
+	// 从密钥提供者获取密钥失败的原因，不为nil时拒绝签发及校验token
+	secretErr error
+}
+
+// 快照的发布状态，实例复制时共享
+type snapshotState struct {
+	mu    sync.Mutex
+	value atomic.Pointer[Snapshot]
+}
+
+// 存活时间 (存活时间 = 超时时间 + 缓存刷新时间)
+func (s *Snapshot) ttl() time.Duration {
+	return time.Duration(s.Timeout+s.MaxRefresh) * time.Second
+}
+
+func (s *Snapshot) diedLine() time.Time {
+	return time.Now().Add(s.ttl())
+}
+
+// 使用当前key及历史key依次解密，解密结果须以32位md5结尾
+func (s *Snapshot) decrypt(data []byte) (plain []byte, err error) {
+	for _, key := range append([][]byte{s.EncryptKey}, s.PrevEncryptKeys...) {
+		if plain, err = gaes.Decrypt(data, key); err == nil && validUuid(plain) {
+			return
+		}
+	}
+	if err == nil {
+		err = gerror.New("decrypt error")
+	}
+	return nil, err
+}
+
+func validUuid(plain []byte) bool {
+	if len(plain) <= 32 {
+		return false
+	}
+	for _, c := range plain[len(plain)-32:] {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Current 返回当前配置快照，不可修改
+// 导出的Timeout、MaxRefresh、ExcludePaths、EncryptKey字段为创建时的配置，热更新后以快照为准
+func (m *GfToken) Current() *Snapshot {
+	if m.snapshot != nil {
+		if s := m.snapshot.value.Load(); s != nil {
+			return s
+		}
+	}
+	return m.newSnapshot()
+}
+
+// 根据字段创建快照
+func (m *GfToken) newSnapshot() *Snapshot {
+	s := &Snapshot{
+		Timeout:      m.Timeout,
+		MaxRefresh:   m.MaxRefresh,
+		ExcludePaths: m.ExcludePaths,
+		EncryptKey:   m.EncryptKey,
+		secretErr:    m.secretErr,
+	}
+	if m.userJwt != nil {
+		s.SigningKey = m.userJwt.SigningKey
+	}
+	s.jwt = m.newJwt(s)
+	return s
+}
+
+func (m *GfToken) newJwt(s *Snapshot) *JwtSign {
+	return &JwtSign{
+		SigningKey: s.SigningKey,
+		VerifyKeys: s.PrevSigningKeys,
+		Issuer:     m.Issuer,
+		Audience:   m.Audience,
+		Leeway:     time.Duration(m.Leeway) * time.Second,
+	}
+}
+
+// 发布初始快照
+func (m *GfToken) initSnapshot() {
+	m.snapshot = &snapshotState{}
+	m.snapshot.value.Store(m.newSnapshot())
+}
+
+// 当前jwt签名器
+func (m *GfToken) jwt() *JwtSign {
+	return m.Current().jwt
+}
+
+// Reload 校验并原子替换配置快照，SigningKey、EncryptKey及历史key为空时保持不变
+// 更换key时原key自动加入历史key，已签发的token在刷新时改用新key；
+// 只修改部分配置时使用 ReloadFunc，避免与并发的热更新相互覆盖
+func (m *GfToken) Reload(s Snapshot) error {
+	return m.ReloadFunc(func(next *Snapshot) error {
+		old := *next
+		*next = s
+		if len(next.SigningKey) == 0 {
+			next.SigningKey = old.SigningKey
+		}
+		if len(next.EncryptKey) == 0 {
+			next.EncryptKey = old.EncryptKey
+		}
+		if next.PrevSigningKeys == nil {
+			next.PrevSigningKeys = old.PrevSigningKeys
+		}
+		if next.PrevEncryptKeys == nil {
+			next.PrevEncryptKeys = old.PrevEncryptKeys
+		}
+		next.secretErr = old.secretErr
+		return nil
+	})
+}
+
+// ReloadFunc 在当前配置快照的副本上执行f并校验后原子替换，读取、修改及替换在同一锁内完成，
+// 并发的热更新依次执行不会相互覆盖；f返回错误时放弃更新，f中不能再调用热更新方法
+//
+//	err := gft.ReloadFunc(func(s *gftoken.Snapshot) error {
+//		s.Timeout = 3600
+//		return nil
+//	})
+func (m *GfToken) ReloadFunc(f func(s *Snapshot) error) error {
+	if m.snapshot == nil {
+		return gerror.New("token instance must be created by NewGfToken")
+	}
+	m.snapshot.mu.Lock()
+	defer m.snapshot.mu.Unlock()
+	old := m.Current()
+	next := *old
+	next.ExcludePaths = append(g.SliceStr{}, old.ExcludePaths...)
+	next.PrevSigningKeys = append([][]byte{}, old.PrevSigningKeys...)
+	next.PrevEncryptKeys = append([][]byte{}, old.PrevEncryptKeys...)
+	if err := f(&next); err != nil {
+		return err
+	}
+	next.ExcludePaths = append(g.SliceStr{}, next.ExcludePaths...)
+	// 获取密钥失败期间未签发过token，原密钥(可能为默认密钥)不加入历史密钥
+	if old.secretErr == nil {
+		next.PrevSigningKeys = rotateKeys(old.SigningKey, next.PrevSigningKeys, next.SigningKey)
+		next.PrevEncryptKeys = rotateKeys(old.EncryptKey, next.PrevEncryptKeys, next.EncryptKey)
+	}
+	if err := validateSnapshot(&next); err != nil {
+		return err
+	}
+	// 只校验更换的密钥，未更换时沿用创建时的校验结果
+	var signingKey, encryptKey []byte
+	if !bytes.Equal(next.SigningKey, old.SigningKey) {
+		signingKey = next.SigningKey
+	}
+	if !bytes.Equal(next.EncryptKey, old.EncryptKey) {
+		encryptKey = next.EncryptKey
+	}
+	if err := m.validateSecrets(signingKey, encryptKey); err != nil {
+		return err
+	}
+	next.jwt = m.newJwt(&next)
+	m.snapshot.value.Store(&next)
+	m.syncTenants()
+	return nil
+}
+
+// 更换key时将原key加入历史key
+func rotateKeys(old []byte, prev [][]byte, current []byte) [][]byte {
+	keys := make([][]byte, 0, len(prev)+1)
+	if !bytes.Equal(old, current) {
+		keys = append(keys, old)
+	}
+	for _, key := range prev {
+		if !bytes.Equal(key, current) && !bytes.Equal(key, old) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > maxPrevKeys {
+		keys = keys[:maxPrevKeys]
+	}
+	return keys
+}
+
+// ReloadConfig 读取配置文件中pattern对应的配置并热更新
+// 只更新超时时间、缓存刷新时间、排除地址及密钥，未配置的项保持不变
+func (m *GfToken) ReloadConfig(ctx context.Context, pattern string) error {
+	config, err := loadConfig(ctx, pattern)
+	if err != nil {
+		return err
+	}
+	var signingKey, encryptKey string
+	if config.SigningKey != "" {
+		if signingKey, err = resolveSecret(config.SigningKey); err != nil {
+			return err
+		}
+	}
+	if config.EncryptKey != "" {
+		if encryptKey, err = resolveSecret(config.EncryptKey); err != nil {
+			return err
+		}
+	}
+	return m.ReloadFunc(func(s *Snapshot) error {
+		if config.Timeout != 0 {
+			s.Timeout = config.Timeout
+		}
+		if config.MaxRefresh != nil {
+			s.MaxRefresh = *config.MaxRefresh
+		}
+		if config.ExcludePaths != nil {
+			s.ExcludePaths = config.ExcludePaths
+		}
+		if signingKey != "" {
+			s.SigningKey = []byte(signingKey)
+		}
+		if encryptKey != "" {
+			s.EncryptKey = []byte(encryptKey)
+		}
+		return nil
+	})
+}
+
+// WatchConfig 监听配置文件及其引用的密钥文件，变化时调用 ReloadConfig 热更新
+// 配置文件不存在(如使用配置中心)时只监听密钥文件；
+// 监听文件所在目录，Kubernetes ConfigMap/Secret 通过替换 ..data 符号链接更新时同样生效；
+// ctx结束时停止监听
+func (m *GfToken) WatchConfig(ctx context.Context, pattern string) error {
+	config, err := loadConfig(ctx, pattern)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	adapterFile, ok := g.Cfg().GetAdapter().(*gcfg.AdapterFile)
+	if ok {
+		if path, _ := adapterFile.GetFilePath(); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	for _, ref := range []string{config.SigningKey, config.EncryptKey} {
+		if gstr.HasPrefix(ref, secretFilePrefix) {
+			paths = append(paths, gfile.Abs(ref[len(secretFilePrefix):]))
+		}
+	}
+	// 按目录归类需要监听的文件名
+	dirs := make(map[string]map[string]bool)
+	for _, path := range paths {
+		dir := gfile.Dir(path)
+		if dirs[dir] == nil {
+			dirs[dir] = make(map[string]bool)
+		}
+		dirs[dir][gfile.Basename(path)] = true
+	}
+	var callbacks []*gfsnotify.Callback
+	stop := func() {
+		for _, callback := range callbacks {
+			if err := gfsnotify.RemoveCallback(callback.Id); err != nil {
+				g.Log().Error(ctx, "[GFToken]stop watching config:", err)
+			}
+		}
+	}
+	for dir, names := range dirs {
+		names := names
+		callback, err := gfsnotify.Add(dir, func(event *gfsnotify.Event) {
+			if !event.IsWrite() && !event.IsCreate() && !event.IsRename() {
+				return
+			}
+			// 只处理监听的文件及Kubernetes的 ..data 等原子替换目录
+			name := gfile.Basename(event.Path)
+			if !names[name] && !gstr.HasPrefix(name, "..") {
+				return
+			}
+			// 清除配置文件解析缓存，确保读取到最新内容
+			if ok {
+				adapterFile.Clear()
+			}
+			if err := m.ReloadConfig(ctx, pattern); err != nil {
+				g.Log().Error(ctx, "[GFToken]reload config:", err)
+			}
+		}, false)
+		if err != nil {
+			stop()
+			return err
+		}
+		callbacks = append(callbacks, callback)
+	}
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+	}
+	return nil
+}
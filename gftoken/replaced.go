@@ -0,0 +1,91 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"time"
+)
+
+// SessionReplaced 会话被新登录顶替的信息
+type SessionReplaced struct {
+	UserKey    string      `json:"userKey"`
+	SessionId  string      `json:"sessionId"`  // 被顶替的会话ID
+	ReplacedAt int64       `json:"replacedAt"` // 顶替时间
+	Device     *DeviceInfo `json:"device"`     // 新登录的设备
+	OldDevice  *DeviceInfo `json:"oldDevice"`  // 被顶替会话的设备
+}
+
+// SessionReplacedError 会话已被新登录顶替
+type SessionReplacedError struct {
+	Replaced *SessionReplaced
+}
+
+func (e *SessionReplacedError) Error() string {
+	return ErrorsSessionReplaced
+}
+
+// 返回给被顶替客户端的信息，不包含用户标识、设备指纹及新登录的IP，
+// 被顶替的token可能已泄露，完整信息只在 OnSessionReplaced 回调中提供
+func (r *SessionReplaced) public() g.Map {
+	data := g.Map{"replacedAt": r.ReplacedAt}
+	if r.Device != nil {
+		data["deviceType"] = r.Device.DeviceType
+		data["deviceName"] = r.Device.DeviceName
+	}
+	return data
+}
+
+// 被顶替会话的标记缓存key，按会话的随机串区分
+func (m *GfToken) replacedKey(uuid string) string {
+	return m.CacheKey + "replaced_" + uuid
+}
+
+// 记录被顶替的会话
+func (m *GfToken) replaceSession(ctx context.Context, old *TokenData, device *DeviceInfo) *SessionReplaced {
+	info := &SessionReplaced{
+		ReplacedAt: time.Now().Unix(),
+		Device:     device,
+		OldDevice:  old.Device,
+	}
+	if claims, err := m.jwt().ParseToken(old.JwtToken); err == nil {
+		info.UserKey = claims.Subject
+		info.SessionId = claims.ID
+	}
+	// 被顶替会话的刷新令牌同时失效，不能再换取新的会话
+	if old.RefreshToken != "" {
+		if err := m.removeCache(ctx, old.RefreshToken); err != nil {
+			g.Log().Error(ctx, "[GFToken]remove replaced refresh token:", err)
+		}
+	}
+	if m.ReplacedTimeout > 0 {
+		err := m.cache.Set(ctx, m.replacedKey(old.UuId), info, time.Duration(m.ReplacedTimeout)*time.Second)
+		if err != nil {
+			g.Log().Error(ctx, "[GFToken]save replaced session:", err)
+		}
+	}
+	return info
+}
+
+// 触发 OnSessionReplaced 回调
+func (m *GfToken) onReplaced(ctx context.Context, info *SessionReplaced) {
+	webSocketSessions.end(info.SessionId, &SessionReplacedError{Replaced: info})
+	if m.onSessionReplaced != nil {
+		m.onSessionReplaced(ctx, info)
+	}
+}
+
+// 查询会话是否已被顶替
+func (m *GfToken) getReplaced(ctx context.Context, uuid string) *SessionReplaced {
+	if m.ReplacedTimeout <= 0 || uuid == "" {
+		return nil
+	}
+	result, err := m.cache.Get(ctx, m.replacedKey(uuid))
+	if err != nil || result.IsEmpty() {
+		return nil
+	}
+	var info *SessionReplaced
+	if err = result.Scan(&info); err != nil {
+		return nil
+	}
+	return info
+}
@@ -0,0 +1,66 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// 退出接口默认响应内容
+var defaultLogoutResponse = g.Map{
+	"code":    0,
+	"message": "退出成功",
+}
+
+// RevokeHandler token撤销接口 (RFC 7009)
+// 调用方需通过 WithClientCredentials 配置的客户端凭证认证，待撤销的token或刷新令牌通过表单参数token提交，
+// token_type_hint为refresh_token时优先按刷新令牌处理，未找到时再按另一种类型处理。
+// 按照规范，token无效或已撤销时同样返回成功。
+// 与 IntrospectHandler 一样，该接口需挂载在 Middleware 之前或加入 ExcludePaths。
+func (m *GfToken) RevokeHandler(r *ghttp.Request) {
+	r.Response.Header().Set("Cache-Control", "no-store")
+	if !m.authenticateClient(r) {
+		m.writeInvalidClient(r)
+		return
+	}
+	ctx := r.GetCtx()
+	if err := m.revoke(ctx, r.Get("token").String(), r.Get("token_type_hint").String()); err != nil {
+		g.Log().Debug(ctx, "[GFToken]revoke token:", err)
+	}
+	if m.revokeResponse != nil {
+		r.Response.WriteJson(m.revokeResponse)
+	}
+}
+
+// 按类型提示撤销token或刷新令牌
+func (m *GfToken) revoke(ctx context.Context, token, hint string) error {
+	if hint == "refresh_token" {
+		if err := m.RevokeRefreshToken(ctx, token); err == nil {
+			return nil
+		}
+		return m.removeToken(ctx, token, EventRevoke)
+	}
+	if err := m.removeToken(ctx, token, EventRevoke); err == nil {
+		return nil
+	}
+	return m.RevokeRefreshToken(ctx, token)
+}
+
+// LogoutHandler 退出登录接口，删除当前请求携带的token并清除cookie
+// 可直接挂载在 Middleware 之后，如 group.POST("/logout", gft.LogoutHandler)
+func (m *GfToken) LogoutHandler(r *ghttp.Request) {
+	ctx := r.GetCtx()
+	if err := m.RemoveToken(ctx, m.GetRequestToken(r)); err != nil {
+		g.Log().Debug(ctx, "[GFToken]logout:", err)
+	}
+	for _, name := range []string{TokenCookieName, RefreshTokenCookieName} {
+		if r.Cookie.Contains(name) {
+			r.Cookie.Remove(name)
+		}
+	}
+	if m.logoutResponse != nil {
+		r.Response.WriteJson(m.logoutResponse)
+		return
+	}
+	r.Response.WriteJson(defaultLogoutResponse)
+}
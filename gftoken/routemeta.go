@@ -0,0 +1,126 @@
+package gftoken
+
+import (
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/gmeta"
+	"reflect"
+	"sync"
+)
+
+// 路由认证方式，通过请求结构体g.Meta的auth标签设置
+const (
+	AuthRequired = "required" // 必须登录，忽略ExcludePaths
+	AuthOptional = "optional" // 携带有效token时校验角色及授权范围，未携带或无效时按未登录继续
+	AuthNone     = "none"     // 不需要登录，忽略ExcludePaths
+)
+
+// g.Meta中的标签名称
+const (
+	MetaTagAuth   = "auth"
+	MetaTagRoles  = "roles"  // 多个以逗号分隔，拥有其中任意一个角色即可
+	MetaTagScopes = "scopes" // 多个以逗号分隔，须拥有全部授权范围
+)
+
+// RouteMeta 路由认证元数据
+//
+//	type UserListReq struct {
+//		g.Meta `path:"/user/list" method:"get" auth:"required" roles:"admin,operator" scopes:"user:read"`
+//	}
+type RouteMeta struct {
+	Auth   string
+	Roles  []string
+	Scopes []string
+}
+
+// 请求结构体类型 => *RouteMeta
+var routeMetaCache sync.Map
+
+// GetRouteMeta 获取当前请求路由的认证元数据，非规范路由或未设置认证标签时返回nil
+func GetRouteMeta(r *ghttp.Request) *RouteMeta {
+	handler := r.GetServeHandler()
+	if handler == nil || handler.Handler == nil {
+		return nil
+	}
+	return routeMetaOfFunc(handler.Handler.Info.Type)
+}
+
+// RouteMetaOf 获取请求结构体的认证元数据，未设置认证标签时返回nil
+func RouteMetaOf(req interface{}) *RouteMeta {
+	return parseRouteMeta(gmeta.Data(req))
+}
+
+// 规范路由函数 func(ctx, *Req) (*Res, error) 的认证元数据
+func routeMetaOfFunc(t reflect.Type) *RouteMeta {
+	if t == nil || t.Kind() != reflect.Func || t.NumIn() != 2 {
+		return nil
+	}
+	reqType := t.In(1)
+	if v, ok := routeMetaCache.Load(reqType); ok {
+		return v.(*RouteMeta)
+	}
+	var meta *RouteMeta
+	if reqType.Kind() == reflect.Ptr && reqType.Elem().Kind() == reflect.Struct {
+		meta = RouteMetaOf(reflect.New(reqType.Elem()).Interface())
+	}
+	routeMetaCache.Store(reqType, meta)
+	return meta
+}
+
+func parseRouteMeta(data map[string]string) *RouteMeta {
+	meta := &RouteMeta{
+		Auth:   gstr.Trim(data[MetaTagAuth]),
+		Roles:  gstr.SplitAndTrim(data[MetaTagRoles], ","),
+		Scopes: gstr.SplitAndTrim(data[MetaTagScopes], ","),
+	}
+	if meta.Auth == "" && len(meta.Roles) == 0 && len(meta.Scopes) == 0 {
+		return nil
+	}
+	return meta
+}
+
+// 路由的认证方式，未设置认证元数据时按ExcludePaths判断
+func (m *GfToken) authMode(urlPath string, meta *RouteMeta) string {
+	if meta != nil {
+		switch meta.Auth {
+		case AuthNone, AuthOptional:
+			return meta.Auth
+		}
+		// 设置为required、只设置了角色或授权范围、或无法识别的值时必须登录
+		return AuthRequired
+	}
+	if !m.AuthPath(urlPath) {
+		return AuthNone
+	}
+	return AuthRequired
+}
+
+// 校验token的角色及授权范围是否满足路由要求
+func (meta *RouteMeta) authorize(claims *CustomClaims) error {
+	if meta == nil || claims == nil {
+		return nil
+	}
+	if len(meta.Roles) > 0 && !containsAny(claims.Roles, meta.Roles) {
+		return ErrForbidden
+	}
+	if len(meta.Scopes) > 0 {
+		granted := gstr.Fields(claims.Scope)
+		for _, scope := range meta.Scopes {
+			if !containsAny(granted, []string{scope}) {
+				return ErrForbidden
+			}
+		}
+	}
+	return nil
+}
+
+func containsAny(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
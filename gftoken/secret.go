@@ -0,0 +1,179 @@
+package gftoken
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/os/gtimer"
+	"github.com/gogf/gf/v2/text/gstr"
+	"os"
+	"time"
+)
+
+// 密钥名称
+const (
+	SecretSigningKey = "jwt_signing_key"   // jwt签名key
+	SecretEncryptKey = "token_encrypt_key" // token加密key，长度须为16、24或32
+)
+
+const (
+	// 派生密钥长度
+	derivedKeyLength = 32
+	// 获取密钥超时时间
+	secretFetchTimeout = 10 * time.Second
+)
+
+// SecretProvider 密钥提供者，可由外部KMS、Vault等实现
+type SecretProvider interface {
+	// GetSecret 获取名称为name的密钥
+	GetSecret(ctx context.Context, name string) ([]byte, error)
+}
+
+// SecretProviderFunc 函数形式的密钥提供者
+type SecretProviderFunc func(ctx context.Context, name string) ([]byte, error)
+
+func (f SecretProviderFunc) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	return f(ctx, name)
+}
+
+// EnvSecretProvider 从环境变量读取密钥，变量名为 前缀+大写的密钥名称，如 APP_JWT_SIGNING_KEY
+type EnvSecretProvider struct {
+	Prefix string
+}
+
+func (p EnvSecretProvider) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	key := p.Prefix + gstr.ToUpper(name)
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil, gerror.Newf(`secret environment variable "%s" is not set`, key)
+	}
+	return []byte(value), nil
+}
+
+// FileSecretProvider 从目录中与密钥名称同名的文件读取密钥，适用于Kubernetes Secret挂载
+type FileSecretProvider struct {
+	Dir string
+}
+
+func (p FileSecretProvider) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	path := gfile.Join(p.Dir, name)
+	if !gfile.IsFile(path) {
+		return nil, gerror.Newf(`secret file "%s" does not exist`, path)
+	}
+	return []byte(gstr.Trim(gfile.GetContents(path))), nil
+}
+
+// HKDFSecretProvider 使用HKDF-SHA256 (RFC 5869) 从主密钥派生各密钥，以密钥名称作为info
+type HKDFSecretProvider struct {
+	// 主密钥来源
+	Master SecretProvider
+	// 主密钥名称
+	MasterName string
+	// 盐值 可为空
+	Salt []byte
+}
+
+func (p HKDFSecretProvider) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	master, err := p.Master.GetSecret(ctx, p.MasterName)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf(master, p.Salt, []byte(name), derivedKeyLength), nil
+}
+
+// HKDF-SHA256 extract-and-expand
+func hkdf(secret, salt, info []byte, length int) []byte {
+	if len(salt) == 0 {
+		salt = make([]byte, sha256.Size)
+	}
+	extractor := hmac.New(sha256.New, salt)
+	extractor.Write(secret)
+	prk := extractor.Sum(nil)
+
+	var (
+		out  = make([]byte, 0, length+sha256.Size)
+		prev []byte
+	)
+	for i := byte(1); len(out) < length; i++ {
+		expander := hmac.New(sha256.New, prk)
+		expander.Write(prev)
+		expander.Write(info)
+		expander.Write([]byte{i})
+		prev = expander.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
+
+// KMSClient 外部密钥管理服务，由使用方对接具体的KMS实现
+type KMSClient interface {
+	// Decrypt 解密由KMS加密的密钥
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// KMSSecretProvider 信封加密，从Source读取KMS加密后的密钥并通过KMS解密
+type KMSSecretProvider struct {
+	Client KMSClient
+	Source SecretProvider
+}
+
+func (p KMSSecretProvider) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	ciphertext, err := p.Source.GetSecret(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return p.Client.Decrypt(ctx, ciphertext)
+}
+
+// 从密钥提供者获取签名key及加密key
+func fetchSecrets(ctx context.Context, provider SecretProvider) (signingKey, encryptKey []byte, err error) {
+	if signingKey, err = provider.GetSecret(ctx, SecretSigningKey); err != nil {
+		return
+	}
+	encryptKey, err = provider.GetSecret(ctx, SecretEncryptKey)
+	return
+}
+
+// RefreshSecrets 重新从密钥提供者获取密钥，密钥变化时热更新，原密钥保留用于校验已签发的token
+func (m *GfToken) RefreshSecrets(ctx context.Context) error {
+	if m.secretProvider == nil {
+		return gerror.New("secret provider is not set")
+	}
+	signingKey, encryptKey, err := fetchSecrets(ctx, m.secretProvider)
+	if err != nil {
+		return err
+	}
+	if conf := m.Current(); conf.secretErr == nil && hmac.Equal(conf.SigningKey, signingKey) && hmac.Equal(conf.EncryptKey, encryptKey) {
+		return nil
+	}
+	return m.ReloadFunc(func(s *Snapshot) error {
+		s.SigningKey, s.EncryptKey, s.secretErr = signingKey, encryptKey, nil
+		return nil
+	})
+}
+
+// 创建时获取密钥失败，记录日志；RefreshSecrets成功前签发及校验token均返回错误，不会使用默认密钥
+func (m *GfToken) logSecretErr() {
+	if m.secretErr != nil {
+		g.Log().Error(gctx.New(), "[GFToken]fetch secrets, token issuing and verification are disabled until refreshed:", m.secretErr)
+	}
+}
+
+// 定时刷新密钥
+func (m *GfToken) startSecretRefresh() {
+	if m.secretProvider == nil || m.secretRefresh <= 0 {
+		return
+	}
+	ctx := gctx.New()
+	m.secretTimer = gtimer.AddSingleton(ctx, m.secretRefresh, func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, secretFetchTimeout)
+		defer cancel()
+		if err := m.RefreshSecrets(ctx); err != nil {
+			g.Log().Error(ctx, "[GFToken]refresh secrets:", err)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/guid"
+	"sort"
+	"time"
+)
+
+// SessionPolicy 会话数量超出限制时的处理方式
+type SessionPolicy int
+
+const (
+	SessionEvictOldest SessionPolicy = iota // 踢出最早登录的会话
+	SessionRejectNew                        // 拒绝新的登录
+)
+
+// SessionLimit 每个用户的会话数量限制，需开启多点登录
+// 设备类型由 DeviceFromContext 识别
+type SessionLimit struct {
+	// 每个用户最多同时存在的会话数 0为不限制
+	Max int
+	// 每种设备类型最多同时存在的会话数，如 {"web": 1, "mobile": 1}；
+	// 客户端可通过 X-Device-Type 请求头自行声明设备类型，不能作为安全限制，
+	// 需要可信的设备类型时在签发token前通过 ContextWithDevice 设置
+	DeviceMax map[string]int
+	// 超出限制时的处理方式
+	Policy SessionPolicy
+}
+
+// 会话索引项
+type sessionEntry struct {
+	Key     string `json:"key"`     // 会话缓存key(不含CacheKey前缀)
+	Device  string `json:"device"`  // 设备类型
+	LoginAt int64  `json:"loginAt"` // 登录时间
+}
+
+const (
+	// 会话索引锁的过期时间，持有锁的实例异常退出时自动释放
+	sessionLockTimeout = 5 * time.Second
+	// 等待会话索引锁的重试间隔
+	sessionLockRetry = 10 * time.Millisecond
+)
+
+// 是否维护用户的会话索引，仅在多点登录并限制会话数量或记录会话列表时维护
+func (m *GfToken) indexSessions() bool {
+	return m.MultiLogin && (m.sessionLimit != nil || m.deviceTracking)
+}
+
+// 会话索引缓存key，多点登录时会话key去除随机后缀即为用户标识
+func (m *GfToken) sessionIndexKey(key string) string {
+	return m.CacheKey + "sessions_" + key[:len(key)-16]
+}
+
+// 锁定用户的会话索引，锁中保存随机的持有者标识，共用redis或磁盘缓存的多个实例之间同样互斥
+// 解锁时仅删除自己持有的锁，避免锁超时后误删其他实例获取的锁
+func (m *GfToken) lockSessions(ctx context.Context, indexKey string) (unlock func(), err error) {
+	var (
+		lockKey  = indexKey + "_lock"
+		owner    = guid.S()
+		deadline = time.Now().Add(sessionLockTimeout)
+	)
+	for {
+		ok, err := m.tryLock(ctx, lockKey, owner, sessionLockTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return func() {
+				if _, e := m.unlock(ctx, lockKey, owner); e != nil {
+					g.Log().Error(ctx, "[GFToken]unlock sessions:", e)
+				}
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, gerror.New("lock user sessions timeout")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(sessionLockRetry):
+		}
+	}
+}
+
+// 读取用户的会话索引，并清理已失效的会话
+func (m *GfToken) loadSessions(ctx context.Context, indexKey string) (list []sessionEntry, err error) {
+	result, err := m.cache.Get(ctx, indexKey)
+	if err != nil || result.IsEmpty() {
+		return
+	}
+	var all []sessionEntry
+	if err = result.Scan(&all); err != nil {
+		return
+	}
+	for _, v := range all {
+		if m.contains(ctx, m.CacheKey+v.Key) {
+			list = append(list, v)
+		}
+	}
+	return
+}
+
+func (m *GfToken) saveSessions(ctx context.Context, indexKey string, list []sessionEntry) error {
+	if len(list) == 0 {
+		return m.removeCache(ctx, indexKey)
+	}
+	return m.setCache(ctx, indexKey, list)
+}
+
+// 检查会话数量限制，按策略踢出旧会话或拒绝登录，返回需要保留的会话索引及被踢出的会话
+func (m *GfToken) checkSessionLimit(ctx context.Context, indexKey string, device *DeviceInfo) (
+	list []sessionEntry, replaced []*SessionReplaced, err error) {
+	list, err = m.loadSessions(ctx, indexKey)
+	if err != nil {
+		return
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LoginAt < list[j].LoginAt
+	})
+	var (
+		limit     = m.sessionLimit
+		deviceMax = limit.DeviceMax[device.DeviceType]
+		evict     = make(map[string]bool)
+	)
+	// 设备类型限制
+	if deviceMax > 0 {
+		var same []sessionEntry
+		for _, v := range list {
+			if v.Device == device.DeviceType {
+				same = append(same, v)
+			}
+		}
+		for i := 0; i <= len(same)-deviceMax; i++ {
+			evict[same[i].Key] = true
+		}
+	}
+	// 总数限制
+	if limit.Max > 0 {
+		over := len(list) - len(evict) - limit.Max + 1
+		for i := 0; i < len(list) && over > 0; i++ {
+			if !evict[list[i].Key] {
+				evict[list[i].Key] = true
+				over--
+			}
+		}
+	}
+	if len(evict) == 0 {
+		return
+	}
+	if limit.Policy == SessionRejectNew {
+		err = ErrSessionLimitExceeded
+		return
+	}
+	kept := list[:0]
+	for _, v := range list {
+		if !evict[v.Key] {
+			kept = append(kept, v)
+			continue
+		}
+		old, e := m.getCache(ctx, m.CacheKey+v.Key)
+		if e == nil && old != nil {
+			replaced = append(replaced, m.replaceSession(ctx, old, device))
+		}
+		if e = m.removeSession(ctx, v.Key); e != nil {
+			g.Log().Error(ctx, "[GFToken]evict session:", e)
+		}
+	}
+	list = kept
+	return
+}
+
+// 删除会话及其关联的刷新令牌
+func (m *GfToken) removeSession(ctx context.Context, key string) (err error) {
+	tData, err := m.getCache(ctx, m.CacheKey+key)
+	if err != nil {
+		return
+	}
+	if tData != nil && tData.RefreshToken != "" {
+		// 刷新令牌可能已先行过期
+		if e := m.removeCache(ctx, tData.RefreshToken); e != nil {
+			g.Log().Debug(ctx, "[GFToken]remove refresh token:", e)
+		}
+	}
+	return m.removeCache(ctx, m.CacheKey+key)
+}
+
+// 从会话索引中移除会话
+func (m *GfToken) dropSession(ctx context.Context, key string) error {
+	indexKey := m.sessionIndexKey(key)
+	unlock, err := m.lockSessions(ctx, indexKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	list, err := m.loadSessions(ctx, indexKey)
+	if err != nil {
+		return err
+	}
+	kept := list[:0]
+	for _, v := range list {
+		if v.Key != key {
+			kept = append(kept, v)
+		}
+	}
+	return m.saveSessions(ctx, indexKey, kept)
+}
+
+// 会话刷新时延长用户会话索引的有效期，避免活跃用户的索引先于会话过期
+func (m *GfToken) touchSessions(ctx context.Context, conf *Snapshot, key string) {
+	if !m.indexSessions() {
+		return
+	}
+	if _, err := m.cache.UpdateExpire(ctx, m.sessionIndexKey(key), conf.ttl()); err != nil {
+		g.Log().Debug(ctx, "[GFToken]touch sessions:", err)
+	}
+}
+
+func newSessionEntry(key, device string) sessionEntry {
+	return sessionEntry{
+		Key:     key,
+		Device:  device,
+		LoginAt: time.Now().UnixMilli(),
+	}
+}
@@ -0,0 +1,307 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// TenantSeparator 租户标识与token之间的分隔符，位于领域标识之后
+const TenantSeparator = "~"
+
+// TenantConfig 租户配置，未设置的项使用实例的配置
+type TenantConfig struct {
+	SigningKey []byte
+	EncryptKey []byte
+	Timeout    int64
+	MaxRefresh *int64 // 为0时token不自动刷新
+}
+
+// TenantConfigProvider 租户配置提供者
+type TenantConfigProvider interface {
+	// GetTenantConfig 获取租户配置，返回nil时使用实例配置，不存在的租户应返回错误；
+	// 只缓存返回了配置的租户，返回nil的租户每次认证都会调用，需自行缓存
+	GetTenantConfig(ctx context.Context, tenant string) (*TenantConfig, error)
+}
+
+// TenantConfigProviderFunc 函数形式的租户配置提供者
+type TenantConfigProviderFunc func(ctx context.Context, tenant string) (*TenantConfig, error)
+
+func (f TenantConfigProviderFunc) GetTenantConfig(ctx context.Context, tenant string) (*TenantConfig, error) {
+	return f(ctx, tenant)
+}
+
+// TenantResolver 从请求中识别租户，返回空字符串表示不属于任何租户
+type TenantResolver func(r *http.Request) string
+
+// HeaderTenant 从请求头识别租户
+func HeaderTenant(header string) TenantResolver {
+	return func(r *http.Request) string {
+		return r.Header.Get(header)
+	}
+}
+
+// HostTenant 从域名识别租户，如suffix为 ".example.com" 时 acme.example.com 的租户为acme
+func HostTenant(suffix string) TenantResolver {
+	return func(r *http.Request) string {
+		host := r.Host
+		if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+			host = host[:i]
+		}
+		if !strings.HasSuffix(host, suffix) {
+			return ""
+		}
+		return host[:len(host)-len(suffix)]
+	}
+}
+
+type tenantCtxKey struct{}
+
+// ContextWithTenant 设置上下文的租户，签发token时使用该租户；
+// 校验token时token所属租户必须与之一致，tenant为空表示只接受不属于任何租户的token
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenant)
+}
+
+// TenantFromContext 获取上下文的租户
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := tenantFromContext(ctx)
+	return tenant
+}
+
+func tenantFromContext(ctx context.Context) (tenant string, ok bool) {
+	tenant, ok = ctx.Value(tenantCtxKey{}).(string)
+	return
+}
+
+// TokenTenant 获取token所属租户，不属于任何租户时返回空字符串
+func TokenTenant(token string) string {
+	tenant, _ := splitTenant(token)
+	return tenant
+}
+
+// 拆分领域标识之后的租户标识
+func splitTenant(token string) (tenant, rest string) {
+	_, rest = splitRealm(token)
+	if i := strings.Index(rest, TenantSeparator); i > 0 {
+		return rest[:i], rest[i+1:]
+	}
+	return "", rest
+}
+
+// 租户标识只能包含字母、数字、下划线及中划线
+func validTenant(tenant string) bool {
+	if tenant == "" || len(tenant) > 64 {
+		return false
+	}
+	for _, c := range tenant {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// 租户实例，与所属实例共用缓存及回调，使用独立的缓存key前缀及配置快照
+type tenantState struct {
+	provider TenantConfigProvider
+	resolver TenantResolver
+	mu       sync.Mutex
+	views    sync.Map // 有独立配置的租户实例
+}
+
+// 保存的租户实例及其配置
+type tenantEntry struct {
+	view   *GfToken
+	config *TenantConfig
+}
+
+// 根据token或上下文中的租户获取租户实例，未开启多租户、不属于任何租户或已是租户实例时返回自身
+func (m *GfToken) scoped(ctx context.Context, token string) (*GfToken, error) {
+	if m.tenants == nil || m.tenant != "" {
+		return m, nil
+	}
+	tenant, ok := tenantFromContext(ctx)
+	if token != "" {
+		if TokenTenant(token) != tenant && ok {
+			return nil, ErrTenantMismatch
+		}
+		tenant = TokenTenant(token)
+	}
+	if tenant == "" {
+		return m, nil
+	}
+	return m.tenantView(ctx, tenant)
+}
+
+// 获取租户实例，只保存提供者返回了独立配置的租户，数量受提供者管理的租户数限制；
+// 使用实例配置的租户每次创建临时实例并共用实例的配置快照，不占用内存
+func (m *GfToken) tenantView(ctx context.Context, tenant string) (*GfToken, error) {
+	if v, ok := m.tenants.views.Load(tenant); ok {
+		return v.(*tenantEntry).view, nil
+	}
+	if !validTenant(tenant) {
+		return nil, gerror.Newf("invalid tenant %q", tenant)
+	}
+	if m.tenants.provider == nil {
+		return m.newTenantView(tenant, m.snapshot), nil
+	}
+	config, err := m.tenantConfig(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return m.newTenantView(tenant, m.snapshot), nil
+	}
+	m.tenants.mu.Lock()
+	defer m.tenants.mu.Unlock()
+	if v, ok := m.tenants.views.Load(tenant); ok {
+		return v.(*tenantEntry).view, nil
+	}
+	s, err := m.tenantSnapshot(m.Current(), config, nil)
+	if err != nil {
+		return nil, err
+	}
+	view := m.newTenantView(tenant, &snapshotState{})
+	s.jwt = view.newJwt(s)
+	view.snapshot.value.Store(s)
+	m.tenants.views.Store(tenant, &tenantEntry{view: view, config: config})
+	return view, nil
+}
+
+func (m *GfToken) newTenantView(tenant string, snapshot *snapshotState) *GfToken {
+	view := *m
+	view.tenant = tenant
+	view.CacheKey = m.CacheKey + tenant + ":"
+	view.snapshot = snapshot
+	return &view
+}
+
+func (m *GfToken) tenantConfig(ctx context.Context, tenant string) (*TenantConfig, error) {
+	config, err := m.tenants.provider.GetTenantConfig(ctx, tenant)
+	if err != nil {
+		return nil, gerror.Wrapf(err, "get tenant %q config", tenant)
+	}
+	return config, nil
+}
+
+// 合并实例快照及租户配置，租户未设置的项使用实例的当前配置及历史密钥；
+// own为租户实例原快照，租户更换密钥时原密钥加入历史密钥
+func (m *GfToken) tenantSnapshot(base *Snapshot, config *TenantConfig, own *Snapshot) (*Snapshot, error) {
+	s := &Snapshot{
+		Timeout:         base.Timeout,
+		MaxRefresh:      base.MaxRefresh,
+		ExcludePaths:    base.ExcludePaths,
+		SigningKey:      base.SigningKey,
+		EncryptKey:      base.EncryptKey,
+		PrevSigningKeys: base.PrevSigningKeys,
+		PrevEncryptKeys: base.PrevEncryptKeys,
+	}
+	// 租户未同时设置两个密钥时沿用实例的密钥，实例获取密钥失败时同样不可用
+	if len(config.SigningKey) == 0 || len(config.EncryptKey) == 0 {
+		s.secretErr = base.secretErr
+	}
+	// 获取密钥失败期间未签发过token，原密钥不加入历史密钥
+	if own != nil && own.secretErr != nil {
+		own = nil
+	}
+	if config.Timeout != 0 {
+		s.Timeout = config.Timeout
+	}
+	if config.MaxRefresh != nil {
+		s.MaxRefresh = *config.MaxRefresh
+	}
+	var signingKey, encryptKey []byte
+	if len(config.SigningKey) > 0 {
+		s.SigningKey, s.PrevSigningKeys = config.SigningKey, nil
+		if own != nil {
+			s.PrevSigningKeys = rotateKeys(own.SigningKey, own.PrevSigningKeys, config.SigningKey)
+		}
+		signingKey = config.SigningKey
+	}
+	if len(config.EncryptKey) > 0 {
+		s.EncryptKey, s.PrevEncryptKeys = config.EncryptKey, nil
+		if own != nil {
+			s.PrevEncryptKeys = rotateKeys(own.EncryptKey, own.PrevEncryptKeys, config.EncryptKey)
+		}
+		encryptKey = config.EncryptKey
+	}
+	if err := validateSnapshot(s); err != nil {
+		return nil, err
+	}
+	if err := m.validateSecrets(signingKey, encryptKey); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReloadTenant 重新获取租户配置并热更新，更换密钥时原密钥加入历史密钥；
+// 未使用过或没有独立配置的租户不处理，提供者不再返回配置时改用实例配置
+func (m *GfToken) ReloadTenant(ctx context.Context, tenant string) error {
+	if m.tenants == nil {
+		return gerror.New("tenancy is not enabled, set it by WithTenants")
+	}
+	m.tenants.mu.Lock()
+	defer m.tenants.mu.Unlock()
+	v, ok := m.tenants.views.Load(tenant)
+	if !ok {
+		return nil
+	}
+	config, err := m.tenantConfig(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		m.tenants.views.Delete(tenant)
+		return nil
+	}
+	entry := v.(*tenantEntry)
+	s, err := m.tenantSnapshot(m.Current(), config, entry.view.Current())
+	if err != nil {
+		return err
+	}
+	s.jwt = entry.view.newJwt(s)
+	entry.view.snapshot.value.Store(s)
+	m.tenants.views.Store(tenant, &tenantEntry{view: entry.view, config: config})
+	return nil
+}
+
+// 实例配置热更新后同步到各租户实例，租户未设置的项改用新的配置及密钥
+func (m *GfToken) syncTenants() {
+	if m.tenants == nil || m.tenant != "" {
+		return
+	}
+	m.tenants.mu.Lock()
+	defer m.tenants.mu.Unlock()
+	base := m.Current()
+	m.tenants.views.Range(func(key, value interface{}) bool {
+		entry := value.(*tenantEntry)
+		s, err := m.tenantSnapshot(base, entry.config, entry.view.Current())
+		if err != nil {
+			g.Log().Errorf(context.Background(), "[GFToken]sync tenant %q config: %+v", key, err)
+			return true
+		}
+		s.jwt = entry.view.newJwt(s)
+		entry.view.snapshot.value.Store(s)
+		return true
+	})
+}
+
+// 按请求识别租户并设置到上下文
+func (m *GfToken) withRequestTenant(ctx context.Context, r *http.Request) context.Context {
+	if m.tenants == nil || m.tenants.resolver == nil {
+		return ctx
+	}
+	return ContextWithTenant(ctx, m.tenants.resolver(r))
+}
+
+// 租户实例签发的token及刷新令牌的前缀
+func (m *GfToken) tenantPrefix() string {
+	if m.tenant == "" {
+		return ""
+	}
+	return m.tenant + TenantSeparator
+}
@@ -0,0 +1,180 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/util/gconv"
+	"math"
+	"time"
+)
+
+// ThrottleKind 登录失败限制维度
+type ThrottleKind string
+
+const (
+	ThrottleAccount ThrottleKind = "account" // 按登录账号
+	ThrottleIP      ThrottleKind = "ip"      // 按客户端IP
+)
+
+// ThrottleConfig 登录失败限制配置，字段为0时使用默认值
+type ThrottleConfig struct {
+	// 计数窗口内允许的最大失败次数，达到后锁定 默认5次
+	MaxAttempts int64
+	// 失败计数窗口 默认15分钟（秒）
+	Window int64
+	// 首次锁定时长 默认1分钟（秒），之后每次锁定时长翻倍
+	LockDuration int64
+	// 最长锁定时长 默认1小时（秒），距上次锁定超过2倍该时长时锁定时长重新计算
+	MaxLockDuration int64
+}
+
+// Lockout 锁定状态
+type Lockout struct {
+	Failures    int64 `json:"failures"`    // 当前窗口内失败次数
+	Locks       int64 `json:"locks"`       // 连续锁定次数
+	LockedUntil int64 `json:"lockedUntil"` // 锁定截止时间戳，0表示未锁定
+}
+
+// Locked 是否处于锁定状态
+func (l *Lockout) Locked() bool {
+	return l.LockedUntil > time.Now().Unix()
+}
+
+func (c *ThrottleConfig) withDefault() *ThrottleConfig {
+	config := *c
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.Window <= 0 {
+		config.Window = 60 * 15
+	}
+	if config.LockDuration <= 0 {
+		config.LockDuration = 60
+	}
+	if config.MaxLockDuration <= 0 {
+		config.MaxLockDuration = 60 * 60
+	}
+	return &config
+}
+
+// 锁定时长 LockDuration * 2^(locks-1)，不超过MaxLockDuration
+func (c *ThrottleConfig) lockDuration(locks int64) int64 {
+	d := float64(c.LockDuration) * math.Pow(2, float64(locks-1))
+	if d > float64(c.MaxLockDuration) {
+		return c.MaxLockDuration
+	}
+	return int64(d)
+}
+
+func (m *GfToken) throttleKey(kind ThrottleKind, name, subject string) string {
+	return m.CacheKey + "throttle_" + string(kind) + "_" + name + "_" + subject
+}
+
+// CheckThrottle 检查账号及IP是否被锁定，锁定时返回剩余锁定时长
+func (m *GfToken) CheckThrottle(ctx context.Context, account, ip string) (locked bool, retryAfter time.Duration, err error) {
+	if m.throttle == nil {
+		return
+	}
+	for kind, subject := range map[ThrottleKind]string{ThrottleAccount: account, ThrottleIP: ip} {
+		if subject == "" {
+			continue
+		}
+		var until int64
+		until, err = m.counter(ctx, m.throttleKey(kind, "lock", subject))
+		if err != nil {
+			return
+		}
+		if d := time.Until(time.Unix(until, 0)); d > retryAfter {
+			locked, retryAfter = true, d
+		}
+	}
+	return
+}
+
+// RecordLoginFailure 记录一次登录失败，失败次数达到上限时锁定对应账号或IP
+func (m *GfToken) RecordLoginFailure(ctx context.Context, account, ip string) (err error) {
+	if m.throttle == nil {
+		return
+	}
+	for kind, subject := range map[ThrottleKind]string{ThrottleAccount: account, ThrottleIP: ip} {
+		if subject == "" {
+			continue
+		}
+		if err = m.recordFailure(ctx, kind, subject); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (m *GfToken) recordFailure(ctx context.Context, kind ThrottleKind, subject string) (err error) {
+	var (
+		config  = m.throttle
+		failKey = m.throttleKey(kind, "fail", subject)
+		n       int64
+		locks   int64
+	)
+	n, err = m.incr(ctx, failKey, time.Duration(config.Window)*time.Second)
+	// 计数为原子递增，每达到一次上限只有一个请求执行锁定，并发的失败请求不会重复累加锁定次数
+	if err != nil || n%config.MaxAttempts != 0 {
+		return
+	}
+	var (
+		locksKey = m.throttleKey(kind, "locks", subject)
+		locksTTL = time.Duration(config.MaxLockDuration*2) * time.Second
+	)
+	if locks, err = m.incr(ctx, locksKey, locksTTL); err != nil {
+		return
+	}
+	// 每次锁定后重新计时，距上次锁定超过2倍最长锁定时长时锁定次数清零
+	if locks > 1 {
+		if _, err = m.cache.UpdateExpire(ctx, locksKey, locksTTL); err != nil {
+			return
+		}
+	}
+	duration := config.lockDuration(locks)
+	// 以字符串保存，与计数器的存储格式保持一致
+	err = m.cache.Set(ctx, m.throttleKey(kind, "lock", subject),
+		gconv.String(time.Now().Unix()+duration), time.Duration(duration)*time.Second)
+	if err != nil {
+		return
+	}
+	_, err = m.cache.Remove(ctx, failKey)
+	return
+}
+
+// RecordLoginSuccess 登录成功后清除账号的失败计数
+func (m *GfToken) RecordLoginSuccess(ctx context.Context, account string) (err error) {
+	if m.throttle == nil || account == "" {
+		return
+	}
+	_, err = m.cache.Remove(ctx, m.throttleKey(ThrottleAccount, "fail", account))
+	return
+}
+
+// GetLockout 查询账号或IP的锁定状态
+func (m *GfToken) GetLockout(ctx context.Context, kind ThrottleKind, subject string) (lockout *Lockout, err error) {
+	if m.throttle == nil {
+		err = gerror.New("login throttle is not enabled")
+		return
+	}
+	lockout = &Lockout{}
+	if lockout.Failures, err = m.counter(ctx, m.throttleKey(kind, "fail", subject)); err != nil {
+		return
+	}
+	if lockout.Locks, err = m.counter(ctx, m.throttleKey(kind, "locks", subject)); err != nil {
+		return
+	}
+	lockout.LockedUntil, err = m.counter(ctx, m.throttleKey(kind, "lock", subject))
+	return
+}
+
+// ResetLockout 解除账号或IP的锁定并清除失败计数
+func (m *GfToken) ResetLockout(ctx context.Context, kind ThrottleKind, subject string) (err error) {
+	for _, name := range []string{"fail", "locks", "lock"} {
+		if err = m.removeCache(ctx, m.throttleKey(kind, name, subject)); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package gftoken
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/net/gtrace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/tiger1103/gfast-token/gftoken"
+
+// span名称
+const (
+	spanAuthMiddleware = "gftoken.authMiddleware"
+	spanDecrypt        = "gftoken.decrypt"
+	spanCacheGet       = "gftoken.cache.get"
+	spanCacheSet       = "gftoken.cache.set"
+	spanCacheRemove    = "gftoken.cache.remove"
+	spanJwtParse       = "gftoken.jwt.parse"
+	spanJwtSign        = "gftoken.jwt.sign"
+)
+
+// span属性，不记录token及用户标识等敏感内容
+const (
+	attrServer    = attribute.Key("gftoken.server")
+	attrOutcome   = attribute.Key("gftoken.outcome")
+	attrReason    = attribute.Key("gftoken.reason")
+	attrRefreshed = attribute.Key("gftoken.refreshed")
+)
+
+// 开启span，未开启链路追踪时返回不记录的span
+func (m *GfToken) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	if !m.tracing {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return gtrace.NewTracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attrServer.String(m.ServerName)),
+	)
+}
+
+// 结束span并记录结果，err为指针以便在defer中读取最终的返回值
+func endSpan(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		// 只记录失败原因分类，错误信息中可能包含token
+		span.SetAttributes(attrOutcome.String(OutcomeFailure), attrReason.String(FailureReason(*err)))
+		span.SetStatus(codes.Error, FailureReason(*err))
+	} else {
+		span.SetAttributes(attrOutcome.String(OutcomeSuccess))
+	}
+	span.End()
+}
+
+// 在当前span上标记token已自动刷新
+func (m *GfToken) traceRefreshed(ctx context.Context) {
+	if m.tracing {
+		trace.SpanFromContext(ctx).SetAttributes(attrRefreshed.Bool(true))
+	}
+}
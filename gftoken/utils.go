@@ -1,33 +1,36 @@
 package gftoken
 
 import (
+	"crypto/subtle"
 	"github.com/gogf/gf/v2/net/ghttp"
+	"net/http"
 )
 
 const (
 	FailedAuthCode = 401
+	ForbiddenCode  = 403
+	ThrottledCode  = 429
 	BearerPrefix   = "Bearer "
 )
 
 type AuthFailed struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
 }
 
 
 func (m *GfToken) GetRequestToken(r *ghttp.Request) (token string) {
 	// 请求头获取
-	n := len(BearerPrefix)
-	auth := r.Header.Get("Authorization")
-	if len(auth) >= n && auth[:n] == BearerPrefix {
-		return auth[n:]
+	if token := bearerToken(r.Header.Get("Authorization")); token != "" {
+		return token
 	}
 	// 查询参数
 	if q := r.Get("token"); !q.IsEmpty() {
 		return q.String()
 	}
 	// Cookies
-	if c := r.Cookie.Get("token"); !c.IsEmpty() {
+	if c := r.Cookie.Get(TokenCookieName); !c.IsEmpty() {
 		return c.String()
 	}
 	return
@@ -40,19 +43,40 @@ func (m *GfToken) GetToken(r *ghttp.Request) (tData *TokenData, err error) {
 }
 
 func (m *GfToken) IsLogin(r *ghttp.Request) (b bool, failed *AuthFailed) {
-	b = true
-	urlPath := r.URL.Path
-	if !m.AuthPath(urlPath) {
-		// 如果不需要认证，继续
-		return
-	}
+	_, failed, _ = m.checkLogin(r)
+	return failed == nil, failed
+}
+
+// 校验请求是否已登录，未通过时返回响应内容及失败原因
+func (m *GfToken) checkLogin(r *ghttp.Request) (identity *Identity, failed *AuthFailed, err error) {
 	token := m.GetRequestToken(r)
-	if m.IsEffective(r.GetCtx(), token) == false {
-		b = false
-		failed = &AuthFailed{
-			Code:    FailedAuthCode,
-			Message: "token已失效",
-		}
+	claims, failed, err := m.authenticate(r.GetCtx(), r.URL.Path, token, GetRouteMeta(r))
+	if claims != nil {
+		identity = m.newIdentity(claims, token)
 	}
 	return
 }
+
+// 校验调用方客户端凭证
+func (m *GfToken) authenticateClient(r *ghttp.Request) bool {
+	clientId, clientSecret, ok := r.Request.BasicAuth()
+	if !ok {
+		clientId = r.GetForm("client_id").String()
+		clientSecret = r.GetForm("client_secret").String()
+	}
+	if clientId == "" {
+		return false
+	}
+	secret, ok := m.clients[clientId]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(clientSecret)) == 1
+}
+
+// 客户端认证失败响应 (RFC 6749 5.2)
+func (m *GfToken) writeInvalidClient(r *ghttp.Request) {
+	r.Response.Header().Set("WWW-Authenticate", `Basic realm="`+m.ServerName+`"`)
+	r.Response.WriteHeader(http.StatusUnauthorized)
+	r.Response.WriteJson(map[string]string{"error": "invalid_client"})
+}
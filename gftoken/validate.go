@@ -0,0 +1,72 @@
+package gftoken
+
+import (
+	"github.com/gogf/gf/v2/errors/gcode"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"strings"
+)
+
+// jwt HS256签名密钥最小长度 (RFC 7518 3.2)
+const minSigningKeyLength = 32
+
+// Validate 校验配置
+func (m *GfToken) Validate() error {
+	switch {
+	case m.CacheKey == "":
+		return invalidConfig("CacheKey must not be empty")
+	case m.cache == nil:
+		return invalidConfig("cache must not be nil")
+	case strings.Contains(m.realm, RealmSeparator):
+		return invalidConfig("realm must not contain " + RealmSeparator)
+	case m.sessionLimit != nil && !m.MultiLogin:
+		return invalidConfig("session limit requires MultiLogin, set it by WithMultiLogin")
+	}
+	s := m.Current()
+	if err := validateSnapshot(s); err != nil {
+		return err
+	}
+	return m.validateSecrets(s.SigningKey, s.EncryptKey)
+}
+
+// 校验可热更新的配置
+func validateSnapshot(s *Snapshot) error {
+	switch {
+	case s.Timeout <= 0:
+		return invalidConfig("Timeout must be greater than 0")
+	case s.MaxRefresh < 0 || s.MaxRefresh > s.Timeout:
+		return invalidConfig("MaxRefresh must be between 0 and Timeout")
+	}
+	switch len(s.EncryptKey) {
+	case 16, 24, 32:
+	default:
+		return invalidConfig("EncryptKey length must be 16, 24 or 32 bytes")
+	}
+	if len(s.SigningKey) == 0 {
+		return invalidConfig("jwt signing key must not be empty")
+	}
+	return nil
+}
+
+// 校验密钥不是默认密钥且长度足够，为nil的密钥不校验
+func (m *GfToken) validateSecrets(signingKey, encryptKey []byte) error {
+	if m.defaultSecrets {
+		return nil
+	}
+	if encryptKey != nil && string(encryptKey) == defaultEncryptKey {
+		return invalidConfig("EncryptKey must not be the default key, set it by WithEncryptKey")
+	}
+	if signingKey == nil {
+		return nil
+	}
+	if string(signingKey) == defaultSigningKey {
+		return invalidConfig("jwt signing key must not be the default key, set it by WithUserJwt")
+	}
+	if len(signingKey) < minSigningKeyLength {
+		return invalidConfig("jwt signing key length must be at least 32 bytes")
+	}
+	return nil
+}
+
+func invalidConfig(text string) error {
+	return gerror.NewCode(gcode.CodeInvalidConfiguration, text)
+}
@@ -0,0 +1,293 @@
+package gftoken
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// WebSocketProtocol 通过子协议携带token时客户端需同时声明的子协议，服务端握手时选择该子协议
+	WebSocketProtocol = "gftoken"
+	// WebSocketProtocolPrefix 携带token的子协议前缀，token使用无填充的base64url编码
+	WebSocketProtocolPrefix = "gftoken.bearer."
+
+	defaultWebSocketAuthTimeout = 10 * time.Second
+	defaultWebSocketRevalidate  = time.Minute
+)
+
+var (
+	ErrWebSocketClosed = errors.New("websocket closed")             // 连接已由服务端或客户端关闭
+	ErrWebSocketOrigin = errors.New("websocket origin not allowed") // 握手请求的Origin不允许访问
+)
+
+// WebSocketOptions WebSocket认证选项
+type WebSocketOptions struct {
+	// AuthTimeout 握手时未携带token时等待首条消息携带token的时长，默认10秒，为负数时不接受首条消息认证
+	AuthTimeout time.Duration
+	// Revalidate 定时重新校验会话的间隔，默认60秒，为负数时只在会话退出、撤销或被顶替时关闭连接
+	Revalidate time.Duration
+	// AllowedOrigins 除同源外允许的Origin，如 https://app.example.com
+	AllowedOrigins []string
+	// CheckOrigin 自定义Origin校验，设置后忽略 AllowedOrigins；
+	// 默认只允许同源及未携带Origin的非浏览器客户端，防止其他站点借助cookie中的token建立连接
+	CheckOrigin func(r *http.Request) bool
+}
+
+func (o WebSocketOptions) withDefault() WebSocketOptions {
+	if o.AuthTimeout == 0 {
+		o.AuthTimeout = defaultWebSocketAuthTimeout
+	}
+	if o.Revalidate == 0 {
+		o.Revalidate = defaultWebSocketRevalidate
+	}
+	return o
+}
+
+func (o WebSocketOptions) checkOrigin(r *http.Request) bool {
+	if o.CheckOrigin != nil {
+		return o.CheckOrigin(r)
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, v := range o.AllowedOrigins {
+		if strings.EqualFold(v, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// WebSocketConn 认证通过的WebSocket连接，会话失效时以1008(Policy Violation)关闭连接
+type WebSocketConn struct {
+	*ghttp.WebSocket
+	Identity *Identity // 连接的用户身份
+	watchKey string
+	done     chan struct{}
+	once     sync.Once
+	err      error
+}
+
+// WebSocketProtocols 返回客户端通过子协议携带token时需声明的子协议列表
+func WebSocketProtocols(token string) []string {
+	return []string{WebSocketProtocol, WebSocketProtocolPrefix + base64.RawURLEncoding.EncodeToString([]byte(token))}
+}
+
+// WebSocket 认证并升级WebSocket连接，token依次从请求头、查询参数token、cookie、子协议及首条消息中获取，
+// 首条消息可以是token本身或 {"token":""} 格式的json；
+// 连接期间定时重新校验会话，会话退出、撤销或被顶替时立即关闭连接，处理结束后需调用 Close
+//
+//	ws, err := gft.WebSocket(r)
+//	if err != nil {
+//		return
+//	}
+//	defer ws.Close()
+//	for {
+//		msgType, msg, err := ws.ReadMessage()
+//		...
+//	}
+func (m *GfToken) WebSocket(r *ghttp.Request, options ...WebSocketOptions) (*WebSocketConn, error) {
+	var (
+		ctx      = m.withRequestTenant(gctx.NeverDone(r.GetCtx()), r.Request)
+		opts     WebSocketOptions
+		identity *Identity
+		err      error
+	)
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	opts = opts.withDefault()
+	// 先校验Origin，跨站请求不校验及刷新token
+	if !opts.checkOrigin(r.Request) {
+		r.Response.WriteHeader(http.StatusForbidden)
+		return nil, ErrWebSocketOrigin
+	}
+	token, header := m.GetRequestToken(r), http.Header{}
+	protocols := websocket.Subprotocols(r.Request)
+	for _, protocol := range protocols {
+		if protocol == WebSocketProtocol {
+			header.Set("Sec-WebSocket-Protocol", WebSocketProtocol)
+		}
+		if token == "" && strings.HasPrefix(protocol, WebSocketProtocolPrefix) {
+			b, _ := base64.RawURLEncoding.DecodeString(protocol[len(WebSocketProtocolPrefix):])
+			token = string(b)
+		}
+	}
+	// 握手时携带token的在升级前校验，失败时按http响应
+	if token != "" || opts.AuthTimeout < 0 {
+		if identity, err = m.VerifyToken(ctx, token); err != nil {
+			r.Response.WriteHeader(http.StatusUnauthorized)
+			r.Response.WriteJson(AuthFailed{Code: FailedAuthCode, Message: ErrorsAuthFailed})
+			return nil, err
+		}
+	}
+	upgrader := websocket.Upgrader{
+		CheckOrigin: opts.checkOrigin,
+	}
+	conn, err := upgrader.Upgrade(r.Response.Writer, r.Request, header)
+	if err != nil {
+		return nil, err
+	}
+	ws := &WebSocketConn{
+		WebSocket: &ghttp.WebSocket{Conn: conn},
+		done:      make(chan struct{}),
+	}
+	if identity == nil {
+		if identity, err = m.webSocketFirstMessage(ctx, ws, opts.AuthTimeout); err != nil {
+			ws.closeWith(err)
+			return nil, err
+		}
+	}
+	ws.Identity = identity
+	ws.watchKey = identity.SessionId
+	// 未携带jti的旧token无法按会话关闭连接，只能通过定时校验关闭
+	webSocketSessions.add(ws)
+	if opts.Revalidate > 0 {
+		go m.revalidateWebSocket(ctx, ws, opts.Revalidate)
+	}
+	return ws, nil
+}
+
+// 读取首条消息中携带的token
+func (m *GfToken) webSocketFirstMessage(ctx context.Context, ws *WebSocketConn, timeout time.Duration) (*Identity, error) {
+	if err := ws.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		return nil, gerror.Wrap(err, "read websocket auth message")
+	}
+	if err = ws.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	token := strings.TrimSpace(string(msg))
+	if strings.HasPrefix(token, "{") {
+		var auth struct {
+			Token string `json:"token"`
+		}
+		if err = json.Unmarshal(msg, &auth); err != nil {
+			return nil, ErrTokenInvalid
+		}
+		token = auth.Token
+	}
+	return m.VerifyToken(ctx, token)
+}
+
+// 定时校验会话，失效时关闭连接；校验不刷新会话，空闲的连接不会延长会话有效期
+func (m *GfToken) revalidateWebSocket(ctx context.Context, ws *WebSocketConn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-ticker.C:
+			if _, err := m.verifyToken(ctx, ws.Identity.Token, false); err != nil {
+				g.Log().Info(ctx, "[GFToken]websocket session ended:", err)
+				ws.closeWith(err)
+				return
+			}
+		}
+	}
+}
+
+// Done 连接关闭时关闭的通道
+func (ws *WebSocketConn) Done() <-chan struct{} {
+	return ws.done
+}
+
+// Err 连接关闭原因，会话失效时为认证失败原因，未关闭时为nil
+func (ws *WebSocketConn) Err() error {
+	select {
+	case <-ws.done:
+		return ws.err
+	default:
+		return nil
+	}
+}
+
+// Close 关闭连接
+func (ws *WebSocketConn) Close() error {
+	ws.shutdown(ErrWebSocketClosed, websocket.CloseNormalClosure, "")
+	return nil
+}
+
+// 会话失效，以1008关闭连接，关闭原因为 FailureReason 的返回值
+func (ws *WebSocketConn) closeWith(err error) {
+	ws.shutdown(err, websocket.ClosePolicyViolation, FailureReason(err))
+}
+
+func (ws *WebSocketConn) shutdown(err error, code int, reason string) {
+	ws.once.Do(func() {
+		ws.err = err
+		close(ws.done)
+		webSocketSessions.remove(ws)
+		// WriteControl 可与读写并发调用
+		_ = ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+		_ = ws.Conn.Close()
+	})
+}
+
+// 按会话索引的WebSocket连接，用于会话退出、撤销或被顶替时立即关闭连接
+type webSocketRegistry struct {
+	mu    sync.Mutex
+	conns map[string]map[*WebSocketConn]struct{}
+}
+
+var webSocketSessions = &webSocketRegistry{conns: make(map[string]map[*WebSocketConn]struct{})}
+
+func (reg *webSocketRegistry) add(ws *WebSocketConn) {
+	if ws.watchKey == "" {
+		return
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.conns[ws.watchKey] == nil {
+		reg.conns[ws.watchKey] = make(map[*WebSocketConn]struct{})
+	}
+	reg.conns[ws.watchKey][ws] = struct{}{}
+}
+
+func (reg *webSocketRegistry) remove(ws *WebSocketConn) {
+	if ws.watchKey == "" {
+		return
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if conns := reg.conns[ws.watchKey]; conns != nil {
+		delete(conns, ws)
+		if len(conns) == 0 {
+			delete(reg.conns, ws.watchKey)
+		}
+	}
+}
+
+// 关闭会话的全部连接
+func (reg *webSocketRegistry) end(watchKey string, err error) {
+	if watchKey == "" {
+		return
+	}
+	reg.mu.Lock()
+	conns := make([]*WebSocketConn, 0, len(reg.conns[watchKey]))
+	for ws := range reg.conns[watchKey] {
+		conns = append(conns, ws)
+	}
+	reg.mu.Unlock()
+	for _, ws := range conns {
+		ws.closeWith(err)
+	}
+}
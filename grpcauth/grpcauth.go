@@ -0,0 +1,157 @@
+package grpcauth
+
+import (
+	"context"
+	"github.com/tiger1103/gfast-token/gftoken"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataKey 携带token的metadata键，值格式与http请求头相同为 "Bearer <token>"
+const MetadataKey = "authorization"
+
+// TokenSource 客户端获取token的方式，如服务账号token
+type TokenSource func(ctx context.Context) (string, error)
+
+type tokenCtxKey struct{}
+
+// ForwardToken 转发当前请求的token，依次使用上下文中认证通过的用户token及服务端请求中携带的token；
+// 会将用户的token发送给下游服务，只应在可信的内部服务之间使用，需显式传给客户端拦截器
+//
+//	grpcauth.UnaryClientInterceptor(grpcauth.ForwardToken)
+func ForwardToken(ctx context.Context) (string, error) {
+	if identity := gftoken.IdentityFromContext(ctx); identity != nil && identity.Token != "" {
+		return identity.Token, nil
+	}
+	return TokenFromIncoming(ctx), nil
+}
+
+// ContextWithToken 设置本次调用需要携带的token，优先于source获取的token
+func ContextWithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenCtxKey{}, token)
+}
+
+// TokenFromIncoming 获取服务端请求metadata中携带的token
+func TokenFromIncoming(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	return bearerToken(md.Get(MetadataKey))
+}
+
+func bearerToken(values []string) string {
+	n := len(gftoken.BearerPrefix)
+	for _, v := range values {
+		if len(v) > n && v[:n] == gftoken.BearerPrefix {
+			return v[n:]
+		}
+	}
+	return ""
+}
+
+// UnaryServerInterceptor 服务端一元拦截器，与 IsEffective 相同校验token并自动刷新，
+// 认证通过后可通过 gftoken.IdentityFromContext 获取用户身份；
+// 方法全名(如 /user.v1.User/Login)匹配 ExcludePaths 时不校验，失败时返回 codes.Unauthenticated
+func UnaryServerInterceptor(gft *gftoken.GfToken) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, gft, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 服务端流式拦截器，校验规则同 UnaryServerInterceptor
+func StreamServerInterceptor(gft *gftoken.GfToken) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), gft, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func authenticate(ctx context.Context, gft *gftoken.GfToken, fullMethod string) (context.Context, error) {
+	if !gft.AuthPath(fullMethod) {
+		return ctx, nil
+	}
+	identity, err := gft.VerifyToken(ctx, TokenFromIncoming(ctx))
+	if err != nil {
+		return ctx, unauthenticated(gft, err)
+	}
+	return gftoken.ContextWithIdentity(ctx, identity), nil
+}
+
+// 认证失败状态，详情中的ErrorInfo.Reason为 gftoken.FailureReason 的返回值
+func unauthenticated(gft *gftoken.GfToken, err error) error {
+	st := status.New(codes.Unauthenticated, err.Error())
+	if detailed, e := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: gftoken.FailureReason(err),
+		Domain: gft.ServerName,
+	}); e == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// UnaryClientInterceptor 客户端一元拦截器，为调用附加token，
+// 依次使用 ContextWithToken 设置的token及source中首个返回非空的token；
+// 默认不转发当前请求的token，需要时传入 ForwardToken；已通过metadata设置authorization时不覆盖
+func UnaryClientInterceptor(source ...TokenSource) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := outgoing(ctx, source)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor 客户端流式拦截器，附加规则同 UnaryClientInterceptor
+func StreamClientInterceptor(source ...TokenSource) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := outgoing(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func outgoing(ctx context.Context, source []TokenSource) (context.Context, error) {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(MetadataKey)) > 0 {
+		return ctx, nil
+	}
+	token, _ := ctx.Value(tokenCtxKey{}).(string)
+	for _, src := range source {
+		if token != "" {
+			break
+		}
+		if src == nil {
+			continue
+		}
+		var err error
+		if token, err = src(ctx); err != nil {
+			return ctx, status.Error(codes.Unauthenticated, err.Error())
+		}
+	}
+	if token == "" {
+		return ctx, nil
+	}
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, gftoken.BearerPrefix+token), nil
+}
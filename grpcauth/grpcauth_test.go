@@ -0,0 +1,114 @@
+package grpcauth_test
+
+import (
+	"context"
+	"errors"
+	"github.com/gogf/gf/v2/crypto/gmd5"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/tiger1103/gfast-token/gftoken"
+	"github.com/tiger1103/gfast-token/grpcauth"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+)
+
+var ctx = context.Background()
+
+func Test_GrpcInterceptor(t *testing.T) {
+	gft := gftoken.NewGfToken(
+		gftoken.WithCacheKey("test_grpc_"),
+		gftoken.WithServerName("grpc"),
+		gftoken.WithExcludePaths(g.SliceStr{"/grpc.health.v1.Health/Watch"}),
+		gftoken.WithGCache(),
+	)
+	var identity *gftoken.Identity
+	capture := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		identity = gftoken.IdentityFromContext(ctx)
+		return handler(ctx, req)
+	}
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(grpcauth.UnaryServerInterceptor(gft), capture),
+		grpc.StreamInterceptor(grpcauth.StreamServerInterceptor(gft)),
+	)
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	key := gmd5.MustEncrypt("grpc")
+	token, err := gft.GenerateToken(ctx, key, "data")
+	gtest.AssertNil(err)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(grpcauth.UnaryClientInterceptor(grpcauth.ForwardToken, func(ctx context.Context) (string, error) {
+			return "", errors.New("no credentials")
+		})),
+		grpc.WithStreamInterceptor(grpcauth.StreamClientInterceptor()),
+	)
+	gtest.AssertNil(err)
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+	// 未开启转发的客户端
+	plainConn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(grpcauth.UnaryClientInterceptor()),
+	)
+	gtest.AssertNil(err)
+	defer plainConn.Close()
+	plain := grpc_health_v1.NewHealthClient(plainConn)
+
+	gtest.C(t, func(t *gtest.T) {
+		// 无token时由source提供，source返回错误
+		_, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		t.Assert(status.Code(err), codes.Unauthenticated)
+
+		_, err = client.Check(grpcauth.ContextWithToken(ctx, "invalid"), &grpc_health_v1.HealthCheckRequest{})
+		st := status.Convert(err)
+		t.Assert(st.Code(), codes.Unauthenticated)
+		t.Assert(st.Details()[0].(*errdetails.ErrorInfo).Reason, "invalid")
+
+		_, err = client.Check(grpcauth.ContextWithToken(ctx, token), &grpc_health_v1.HealthCheckRequest{})
+		t.AssertNil(err)
+		t.Assert(identity.UserKey, key)
+		t.Assert(identity.Server, "grpc")
+
+		// 显式开启转发时转发上下文中已认证用户的token
+		identity = nil
+		userCtx := gftoken.ContextWithIdentity(ctx, &gftoken.Identity{Token: token})
+		_, err = client.Check(userCtx, &grpc_health_v1.HealthCheckRequest{})
+		t.AssertNil(err)
+		t.Assert(identity.UserKey, key)
+		// 默认不转发
+		_, err = plain.Check(userCtx, &grpc_health_v1.HealthCheckRequest{})
+		t.Assert(status.Code(err), codes.Unauthenticated)
+		_, err = plain.Check(grpcauth.ContextWithToken(ctx, token), &grpc_health_v1.HealthCheckRequest{})
+		t.AssertNil(err)
+
+		// 排除的流式方法无需token
+		stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+		t.AssertNil(err)
+		res, err := stream.Recv()
+		t.AssertNil(err)
+		t.Assert(res.Status, grpc_health_v1.HealthCheckResponse_SERVING)
+
+		t.AssertNil(gft.RemoveToken(ctx, token))
+		_, err = client.Check(grpcauth.ContextWithToken(ctx, token), &grpc_health_v1.HealthCheckRequest{})
+		st = status.Convert(err)
+		t.Assert(st.Code(), codes.Unauthenticated)
+		t.Assert(st.Details()[0].(*errdetails.ErrorInfo).Reason, "revoked")
+	})
+}
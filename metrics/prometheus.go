@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gtimer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tiger1103/gfast-token/gftoken"
+	"sync"
+	"time"
+)
+
+const (
+	defaultNamespace        = "gftoken"
+	defaultSessionsInterval = time.Minute
+)
+
+// Prometheus gftoken.Metrics 的Prometheus实现，同时实现 prometheus.Collector
+//
+//	m := metrics.NewPrometheus()
+//	prometheus.MustRegister(m)
+//	gft := gftoken.NewGfToken(gftoken.WithMetrics(m))
+//	m.WatchSessions(gft)
+//	defer m.Stop()
+type Prometheus struct {
+	operations *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	sessions   *prometheus.Desc
+	mu         sync.RWMutex
+	tokens     []*gftoken.GfToken
+	counts     map[*gftoken.GfToken]int // 最近一次统计的会话数
+	interval   time.Duration
+	timer      *gtimer.Entry
+}
+
+// NewPrometheus 创建Prometheus指标，namespace默认为gftoken
+func NewPrometheus(namespace ...string) *Prometheus {
+	ns := defaultNamespace
+	if len(namespace) > 0 && namespace[0] != "" {
+		ns = namespace[0]
+	}
+	return &Prometheus{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "operations_total",
+			Help:      "Total number of token operations.",
+		}, []string{"server", "operation", "outcome", "reason"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of token operations.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"server", "operation", "outcome"}),
+		sessions: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "", "active_sessions"),
+			"Number of active sessions in cache.",
+			[]string{"server"}, nil,
+		),
+		counts:   make(map[*gftoken.GfToken]int),
+		interval: defaultSessionsInterval,
+	}
+}
+
+// ObserveOperation 实现 gftoken.Metrics
+func (p *Prometheus) ObserveOperation(server, op, outcome, reason string, duration time.Duration) {
+	p.operations.WithLabelValues(server, op, outcome, reason).Inc()
+	p.duration.WithLabelValues(server, op, outcome).Observe(duration.Seconds())
+}
+
+// SetSessionsInterval 设置统计活跃会话数的间隔，默认1分钟，需在 WatchSessions 之前调用
+func (p *Prometheus) SetSessionsInterval(interval time.Duration) *Prometheus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interval = interval
+	return p
+}
+
+// WatchSessions 统计指定实例的活跃会话数，添加时立即统计一次，之后在后台定时统计，
+// 采集时输出最近一次的结果，不在采集时遍历缓存；实例的ServerName不能重复
+func (p *Prometheus) WatchSessions(tokens ...*gftoken.GfToken) *Prometheus {
+	ctx := gctx.New()
+	for _, token := range tokens {
+		p.countSessions(ctx, token)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokens = append(p.tokens, tokens...)
+	if p.timer == nil {
+		p.timer = gtimer.AddSingleton(ctx, p.interval, p.countAll)
+	}
+	return p
+}
+
+// Stop 停止统计活跃会话数
+func (p *Prometheus) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Close()
+		p.timer = nil
+	}
+}
+
+func (p *Prometheus) countAll(ctx context.Context) {
+	p.mu.RLock()
+	tokens := append([]*gftoken.GfToken(nil), p.tokens...)
+	p.mu.RUnlock()
+	for _, token := range tokens {
+		p.countSessions(ctx, token)
+	}
+}
+
+func (p *Prometheus) countSessions(ctx context.Context, token *gftoken.GfToken) {
+	count, err := token.CountSessions(ctx)
+	if err != nil {
+		g.Log().Error(ctx, "[GFToken]count sessions:", err)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counts[token] = count
+}
+
+// Describe 实现 prometheus.Collector
+func (p *Prometheus) Describe(ch chan<- *prometheus.Desc) {
+	p.operations.Describe(ch)
+	p.duration.Describe(ch)
+	ch <- p.sessions
+}
+
+// Collect 实现 prometheus.Collector
+func (p *Prometheus) Collect(ch chan<- prometheus.Metric) {
+	p.operations.Collect(ch)
+	p.duration.Collect(ch)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, token := range p.tokens {
+		if count, ok := p.counts[token]; ok {
+			ch <- prometheus.MustNewConstMetric(p.sessions, prometheus.GaugeValue, float64(count), token.ServerName)
+		}
+	}
+}
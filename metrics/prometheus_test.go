@@ -0,0 +1,53 @@
+package metrics_test
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/crypto/gmd5"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tiger1103/gfast-token/gftoken"
+	"github.com/tiger1103/gfast-token/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ctx = context.Background()
+
+func Test_Prometheus(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			prom = metrics.NewPrometheus()
+			gft  = gftoken.NewGfToken(
+				gftoken.WithServerName("metricsServer"),
+				gftoken.WithCacheKey("test_prometheus_"),
+				gftoken.WithMetrics(prom),
+				gftoken.WithGCache(),
+			)
+		)
+		token, err := gft.GenerateToken(ctx, gmd5.MustEncrypt("prometheus"), nil)
+		t.AssertNil(err)
+		t.Assert(gft.IsEffective(ctx, token), true)
+		prom.SetSessionsInterval(100 * time.Millisecond).WatchSessions(gft)
+		defer prom.Stop()
+
+		registry := prometheus.NewRegistry()
+		t.AssertNil(registry.Register(prom))
+		t.AssertNil(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP gftoken_active_sessions Number of active sessions in cache.
+# TYPE gftoken_active_sessions gauge
+gftoken_active_sessions{server="metricsServer"} 1
+`), "gftoken_active_sessions"))
+		t.Assert(testutil.CollectAndCount(prom, "gftoken_operations_total") > 0, true)
+
+		// 会话数定时统计，采集时返回最近一次的结果
+		t.AssertNil(gft.RemoveToken(ctx, token))
+		time.Sleep(300 * time.Millisecond)
+		t.AssertNil(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP gftoken_active_sessions Number of active sessions in cache.
+# TYPE gftoken_active_sessions gauge
+gftoken_active_sessions{server="metricsServer"} 0
+`), "gftoken_active_sessions"))
+	})
+}